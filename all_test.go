@@ -1,16 +1,25 @@
 package tinyrpc
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
+	"io"
 	"log"
 	"net"
 	"net/rpc"
+	"net/rpc/jsonrpc"
 	"reflect"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/zehuamama/tinyrpc/codec"
 	"github.com/zehuamama/tinyrpc/compressor"
+	"github.com/zehuamama/tinyrpc/header"
+	"github.com/zehuamama/tinyrpc/metadata"
+	"github.com/zehuamama/tinyrpc/serializer"
 	js "github.com/zehuamama/tinyrpc/test.data/json"
 	pb "github.com/zehuamama/tinyrpc/test.data/message"
 )
@@ -26,6 +35,10 @@ func init() {
 	if err != nil {
 		log.Fatal(err)
 	}
+	err = server.Register(slowService)
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	go server.Serve(lis)
 
@@ -42,6 +55,25 @@ func init() {
 	go server.Serve(lis)
 }
 
+// serve starts the server on a random port and returns a client connected to it
+func serve(t *testing.T, server *Server, opts ...Option) *Client {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve(lis)
+	conn, err := net.Dial("tcp", lis.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := NewClient(conn, opts...)
+	t.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+	return client
+}
+
 // test client synchronously call
 func client_call(t *testing.T, comporessType compressor.CompressType) {
 	conn, err := net.Dial("tcp", ":8008")
@@ -285,3 +317,408 @@ func TestNewClientWithSerializer(t *testing.T) {
 		})
 	}
 }
+
+var slowService = &SlowService{abandoned: make(chan error, 1)}
+
+// SlowService .
+type SlowService struct {
+	abandoned chan error
+}
+
+// Sleep sleeps args.A milliseconds before replying
+func (_ *SlowService) Sleep(args *pb.ArithRequest, reply *pb.ArithResponse) error {
+	time.Sleep(time.Duration(args.A) * time.Millisecond)
+	reply.C = args.A
+	return nil
+}
+
+// TestClient_CallContext .
+func TestClient_CallContext(t *testing.T) {
+	conn, err := net.Dial("tcp", ":8008")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer conn.Close()
+	client := NewClient(conn)
+	defer client.Close()
+
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	cases := []struct {
+		name    string
+		timeout time.Duration
+		ctx     context.Context
+		arg     *pb.ArithRequest
+		expect  error
+	}{
+		{"test-1", time.Second, context.Background(), &pb.ArithRequest{A: 10}, nil},
+		{"test-2", 20 * time.Millisecond, context.Background(), &pb.ArithRequest{A: 200}, context.DeadlineExceeded},
+		{"test-3", time.Second, canceled, &pb.ArithRequest{A: 10}, context.Canceled},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(c.ctx, c.timeout)
+			defer cancel()
+			reply := &pb.ArithResponse{}
+			err := client.CallContext(ctx, "SlowService.Sleep", c.arg, reply)
+			assert.Equal(t, c.expect, err)
+			if err != nil {
+				assert.Equal(t, float64(0), reply.C)
+			}
+		})
+	}
+
+	// the late response of test-2 must be discarded
+	time.Sleep(250 * time.Millisecond)
+	client.mutex.Lock()
+	assert.Equal(t, 0, len(client.pending))
+	client.mutex.Unlock()
+
+	reply := &pb.ArithResponse{}
+	err = client.CallContext(context.Background(), "ArithService.Add", &pb.ArithRequest{A: 20, B: 5}, reply)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, float64(25), reply.C)
+}
+
+// Wait waits args.A milliseconds unless the client gives up earlier
+func (s *SlowService) Wait(ctx context.Context, args *pb.ArithRequest, reply *pb.ArithResponse) error {
+	select {
+	case <-time.After(time.Duration(args.A) * time.Millisecond):
+		reply.C = args.A
+		return nil
+	case <-ctx.Done():
+		s.abandoned <- ctx.Err()
+		return ctx.Err()
+	}
+}
+
+// TestServer_Deadline .
+func TestServer_Deadline(t *testing.T) {
+	conn, err := net.Dial("tcp", ":8008")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer conn.Close()
+	client := NewClient(conn)
+	defer client.Close()
+
+	reply := &pb.ArithResponse{}
+	err = client.Call("SlowService.Wait", &pb.ArithRequest{A: 10}, reply)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, float64(10), reply.C)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err = client.CallContext(ctx, "SlowService.Wait", &pb.ArithRequest{A: 5000}, reply)
+	assert.Equal(t, context.DeadlineExceeded, err)
+
+	select {
+	case err = <-slowService.abandoned:
+		assert.Equal(t, context.DeadlineExceeded, err)
+		assert.Less(t, int64(time.Since(start)), int64(time.Second))
+	case <-time.After(time.Second):
+		t.Fatal("handler did not see the deadline")
+	}
+}
+
+// TestServer_Cancel .
+func TestServer_Cancel(t *testing.T) {
+	conn, err := net.Dial("tcp", ":8008")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer conn.Close()
+	client := NewClient(conn)
+	defer client.Close()
+
+	// without a deadline, the server learns about the cancellation from the client
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	reply := &pb.ArithResponse{}
+	err = client.CallContext(ctx, "SlowService.Wait", &pb.ArithRequest{A: 5000}, reply)
+	assert.Equal(t, context.Canceled, err)
+
+	select {
+	case err = <-slowService.abandoned:
+		assert.Equal(t, context.Canceled, err)
+	case <-time.After(time.Second):
+		t.Fatal("handler did not see the cancellation")
+	}
+}
+
+// TestServer_Shutdown .
+func TestServer_Shutdown(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		log.Fatal(err)
+	}
+	server := NewServer()
+	err = server.Register(new(SlowService))
+	assert.Equal(t, nil, err)
+	served := make(chan error, 1)
+	go func() {
+		served <- server.Serve(lis)
+	}()
+
+	conn, err := net.Dial("tcp", lis.Addr().String())
+	if err != nil {
+		log.Fatal(err)
+	}
+	client := NewClient(conn)
+	defer client.Close()
+
+	reply := &pb.ArithResponse{}
+	call := client.AsyncCall("SlowService.Sleep", &pb.ArithRequest{A: 200}, reply)
+	time.Sleep(50 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	shutdown := make(chan error, 1)
+	go func() {
+		shutdown <- server.Shutdown(ctx)
+	}()
+	assert.Equal(t, ErrServerClosed, <-served)
+
+	// the call in flight is still answered
+	result := <-call
+	assert.Equal(t, nil, result.Error)
+	assert.Equal(t, float64(200), reply.C)
+	assert.Equal(t, nil, <-shutdown)
+
+	// the client has been told to stop sending requests
+	err = client.Call("SlowService.Sleep", &pb.ArithRequest{A: 1}, reply)
+	assert.Equal(t, rpc.ErrShutdown, err)
+	assert.Equal(t, ErrServerClosed, server.Serve(lis))
+}
+
+// TestServer_ShutdownLateRequest .
+func TestServer_ShutdownLateRequest(t *testing.T) {
+	server := NewServer()
+	err := server.Register(new(pb.ArithService))
+	assert.Equal(t, nil, err)
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve(lis)
+	conn, err := net.Dial("tcp", lis.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	cc := codec.NewClient(conn, compressor.Raw, serializer.Proto, codec.Limits{})
+	defer cc.Close()
+	assert.Eventually(t, func() bool {
+		return connCount(server) == 1
+	}, time.Second, time.Millisecond)
+
+	shutdown := make(chan error, 1)
+	go func() {
+		shutdown <- server.Shutdown(context.Background())
+	}()
+	// the request crosses GOAWAY on the wire
+	time.Sleep(50 * time.Millisecond)
+	err = cc.WriteRequest(&header.RequestHeader{ID: 7, Method: "ArithService.Add"}, &pb.ArithRequest{A: 20, B: 5})
+	assert.Equal(t, nil, err)
+
+	var h header.ResponseHeader
+	assert.Equal(t, nil, cc.ReadResponseHeader(&h))
+	assert.Equal(t, header.FlagGoAway, h.Flags)
+	assert.Equal(t, nil, cc.ReadResponseBody(&h, nil))
+
+	// it is answered as not handled instead of being lost
+	h.ResetHeader()
+	assert.Equal(t, nil, cc.ReadResponseHeader(&h))
+	assert.Equal(t, uint64(7), h.ID)
+	assert.Equal(t, errServerClosedStatus.Error(), responseError(&h).Error())
+	assert.Equal(t, nil, cc.ReadResponseBody(&h, nil))
+	assert.Equal(t, nil, <-shutdown)
+}
+
+// TestServer_Close .
+func TestServer_Close(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		log.Fatal(err)
+	}
+	server := NewServer()
+	err = server.Register(new(SlowService))
+	assert.Equal(t, nil, err)
+	served := make(chan error, 1)
+	go func() {
+		served <- server.Serve(lis)
+	}()
+
+	conn, err := net.Dial("tcp", lis.Addr().String())
+	if err != nil {
+		log.Fatal(err)
+	}
+	client := NewClient(conn)
+	defer client.Close()
+
+	call := client.AsyncCall("SlowService.Sleep", &pb.ArithRequest{A: 200}, &pb.ArithResponse{})
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, nil, server.Close())
+	assert.Equal(t, ErrServerClosed, <-served)
+
+	// the call in flight is dropped
+	result := <-call
+	assert.NotEqual(t, nil, result.Error)
+}
+
+// TestServer_ForeignTraffic .
+func TestServer_ForeignTraffic(t *testing.T) {
+	conn, err := net.Dial("tcp", ":8008")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	assert.Equal(t, nil, err)
+
+	// the server hangs up without waiting for more data
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, err = conn.Read(make([]byte, 1))
+	assert.Equal(t, io.EOF, err)
+}
+
+// TestServer_MaxMessageSize .
+func TestServer_MaxMessageSize(t *testing.T) {
+	server := NewServer(WithMaxMessageSize(8))
+	err := server.Register(new(pb.ArithService))
+	assert.Equal(t, nil, err)
+	client := serve(t, server)
+
+	// the request is rejected and the connection is closed
+	resp := &pb.ArithResponse{}
+	err = client.Call("ArithService.Add", &pb.ArithRequest{A: 20, B: 5}, resp)
+	assert.Contains(t, err.Error(), "exceeds the limit 8")
+
+	err = client.Call("ArithService.Add", &pb.ArithRequest{A: 20, B: 5}, resp)
+	assert.NotEqual(t, nil, err)
+}
+
+// TestClient_MaxMessageSize .
+func TestClient_MaxMessageSize(t *testing.T) {
+	server := NewServer()
+	err := server.Register(new(pb.ArithService))
+	assert.Equal(t, nil, err)
+	client := serve(t, server, WithMaxMessageSize(4))
+
+	resp := &pb.ArithResponse{}
+	err = client.Call("ArithService.Add", &pb.ArithRequest{A: 20, B: 5}, resp)
+	var tooLarge *codec.TooLargeError
+	assert.Equal(t, true, errors.As(err, &tooLarge))
+
+	err = client.Call("ArithService.Add", &pb.ArithRequest{A: 20, B: 5}, resp)
+	assert.Equal(t, rpc.ErrShutdown, err)
+}
+
+// TestServer_MaxHeaderSize .
+func TestServer_MaxHeaderSize(t *testing.T) {
+	server := NewServer(WithMaxHeaderSize(16))
+	err := server.Register(new(pb.ArithService))
+	assert.Equal(t, nil, err)
+	client := serve(t, server)
+
+	resp := &pb.ArithResponse{}
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "key", "a long enough value")
+	err = client.CallContext(ctx, "ArithService.Add", &pb.ArithRequest{A: 20, B: 5}, resp)
+	assert.NotEqual(t, nil, err)
+}
+
+// TestNetRPCCodecAdapter net/rpc codecs work with the native server and client
+func TestNetRPCCodecAdapter(t *testing.T) {
+	server := NewServer()
+	err := server.Register(new(js.TestService))
+	assert.Equal(t, nil, err)
+	defer server.Close()
+
+	cliConn, svrConn := net.Pipe()
+	go server.ServeCodec(codec.FromRPCServerCodec(jsonrpc.NewServerCodec(svrConn)))
+	rpcClient := jsonrpc.NewClient(cliConn)
+	defer rpcClient.Close()
+
+	reply := &js.Response{}
+	err = rpcClient.Call("TestService.Add", &js.Request{A: 20, B: 5}, reply)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, float64(25), reply.C)
+	err = rpcClient.Call("TestService.Div", &js.Request{A: 20}, reply)
+	assert.Equal(t, rpc.ServerError("divided is zero"), err)
+
+	rpcServer := rpc.NewServer()
+	err = rpcServer.Register(new(js.TestService))
+	assert.Equal(t, nil, err)
+	cliConn, svrConn = net.Pipe()
+	go rpcServer.ServeCodec(jsonrpc.NewServerCodec(svrConn))
+	client := NewClientWithCodec(codec.FromRPCClientCodec(jsonrpc.NewClientCodec(cliConn)))
+	defer client.Close()
+
+	reply = &js.Response{}
+	err = client.CallContext(context.Background(), "TestService.Mul", &js.Request{A: 20, B: 5}, reply)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, float64(100), reply.C)
+	err = client.Call("TestService.Div", &js.Request{A: 20}, reply)
+	assert.Equal(t, rpc.ServerError("divided is zero"), err)
+}
+
+type EventService struct {
+	events chan float64
+}
+
+func (s *EventService) Log(ctx context.Context, args *pb.ArithRequest, reply *pb.ArithResponse) error {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if md.Get("drop") != "" {
+		return errors.New("dropped")
+	}
+	s.events <- args.A
+	return nil
+}
+
+// countingCodec counts the responses written by the server
+type countingCodec struct {
+	codec.ServerCodec
+	responses int32
+}
+
+func (c *countingCodec) WriteResponse(h *header.ResponseHeader, param interface{}) error {
+	atomic.AddInt32(&c.responses, 1)
+	return c.ServerCodec.WriteResponse(h, param)
+}
+
+// TestClient_Notify .
+func TestClient_Notify(t *testing.T) {
+	server := NewServer()
+	events := &EventService{events: make(chan float64, 10)}
+	err := server.Register(events)
+	assert.Equal(t, nil, err)
+	err = server.Register(new(pb.ArithService))
+	assert.Equal(t, nil, err)
+	cliConn, svrConn := net.Pipe()
+	cc := &countingCodec{ServerCodec: codec.NewServer(svrConn, serializer.Proto, codec.Limits{})}
+	go server.ServeCodec(cc)
+	client := NewClient(cliConn)
+	defer client.Close()
+
+	// failures are not reported back
+	ctx := metadata.NewOutgoingContext(context.Background(), metadata.Pairs("drop", "1"))
+	assert.Equal(t, nil, client.NotifyContext(ctx, "EventService.Log", &pb.ArithRequest{A: 1}))
+	assert.Equal(t, nil, client.Notify("EventService.Missing", &pb.ArithRequest{A: 2}))
+	assert.Equal(t, nil, client.Notify("EventService.Log", &pb.ArithRequest{A: 3}))
+	assert.Equal(t, float64(3), <-events.events)
+
+	client.mutex.Lock()
+	assert.Equal(t, 0, len(client.pending))
+	client.mutex.Unlock()
+
+	// the responses of later calls are not confused with notifications
+	resp := &pb.ArithResponse{}
+	err = client.Call("ArithService.Add", &pb.ArithRequest{A: 20, B: 5}, resp)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, float64(25), resp.C)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&cc.responses))
+
+	client.Close()
+	assert.Equal(t, rpc.ErrShutdown, client.Notify("EventService.Log", &pb.ArithRequest{A: 4}))
+}
@@ -0,0 +1,44 @@
+// Copyright 2022 <mzh.scnu@qq.com>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tinyrpc
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+const (
+	defaultBackoffBase = 100 * time.Millisecond
+	defaultBackoffMax  = 10 * time.Second
+	backoffJitter      = 0.2
+)
+
+var (
+	randMutex sync.Mutex // protects random
+	random    = rand.New(rand.NewSource(time.Now().UnixNano()))
+)
+
+// backoff computes exponentially growing delays
+type backoff struct {
+	base time.Duration // delay of the first attempt
+	max  time.Duration // upper bound of the delay
+}
+
+// delay returns how long to wait before the given attempt, counted from 0,
+// the delay is randomized by ±20% so clients don't retry in lockstep
+func (b backoff) delay(attempt int) time.Duration {
+	d := b.base
+	for i := 0; i < attempt && d < b.max; i++ {
+		d *= 2
+	}
+	if d > b.max {
+		d = b.max
+	}
+	randMutex.Lock()
+	f := 1 + backoffJitter*(2*random.Float64()-1)
+	randMutex.Unlock()
+	return time.Duration(float64(d) * f)
+}
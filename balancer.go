@@ -0,0 +1,228 @@
+// Copyright 2022 <mzh.scnu@qq.com>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tinyrpc
+
+import (
+	"context"
+	"errors"
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/zehuamama/tinyrpc/metadata"
+)
+
+// hashReplicas is the number of points an address has on a hash ring
+const hashReplicas = 100
+
+var errUnknownEndpoint = errors.New("tinyrpc: balancer picked an unknown endpoint")
+
+// Endpoint is a connection of a ClientConn a Balancer can pick
+type Endpoint interface {
+	// Addr returns the address of the server
+	Addr() string
+	// Pending returns the number of calls waiting for a response
+	Pending() int
+}
+
+// PickInfo describes the call a connection is picked for
+type PickInfo struct {
+	// Ctx is the context of the call, it carries the outgoing metadata
+	Ctx           context.Context
+	ServiceMethod string
+}
+
+// Balancer spreads the calls of a ClientConn over its connections
+type Balancer interface {
+	// Pick returns one of the endpoints, which are the connections able to
+	// send a call, there is at least one. It is called concurrently.
+	Pick(endpoints []Endpoint, info PickInfo) (Endpoint, error)
+}
+
+// WithBalancer sets the balancer of a ClientConn, the default is RoundRobin.
+//
+// Whatever the balancer, an endpoint whose calls fail with transport errors,
+// see IsTransportError, is left out for the reconnect backoff delay, which
+// grows with every failure in a row. It is picked again if no other endpoint
+// is left.
+func WithBalancer(b Balancer) Option {
+	return func(o *options) {
+		o.balancer = b
+	}
+}
+
+// randIntn returns a random number in [0, n)
+func randIntn(n int) int {
+	randMutex.Lock()
+	defer randMutex.Unlock()
+	return random.Intn(n)
+}
+
+type roundRobin struct {
+	next uint64
+}
+
+// RoundRobin returns a balancer which picks the endpoints in turn
+func RoundRobin() Balancer {
+	return &roundRobin{}
+}
+
+func (b *roundRobin) Pick(endpoints []Endpoint, info PickInfo) (Endpoint, error) {
+	n := atomic.AddUint64(&b.next, 1) - 1
+	return endpoints[n%uint64(len(endpoints))], nil
+}
+
+type randomBalancer struct{}
+
+// Random returns a balancer which picks an endpoint at random
+func Random() Balancer {
+	return randomBalancer{}
+}
+
+func (randomBalancer) Pick(endpoints []Endpoint, info PickInfo) (Endpoint, error) {
+	return endpoints[randIntn(len(endpoints))], nil
+}
+
+type leastPending struct{}
+
+// LeastPending returns a balancer which picks the endpoint with the fewest
+// calls waiting for a response, ties are broken at random
+func LeastPending() Balancer {
+	return leastPending{}
+}
+
+func (leastPending) Pick(endpoints []Endpoint, info PickInfo) (Endpoint, error) {
+	var best Endpoint
+	min, ties := 0, 0
+	for _, e := range endpoints {
+		pending := e.Pending()
+		switch {
+		case best == nil || pending < min:
+			best, min, ties = e, pending, 1
+		case pending == min:
+			// every tie is kept with the same probability
+			ties++
+			if randIntn(ties) == 0 {
+				best = e
+			}
+		}
+	}
+	return best, nil
+}
+
+type p2c struct{}
+
+// P2C returns a balancer which picks two endpoints at random
+// and uses the one with fewer calls waiting for a response
+func P2C() Balancer {
+	return p2c{}
+}
+
+func (p2c) Pick(endpoints []Endpoint, info PickInfo) (Endpoint, error) {
+	n := len(endpoints)
+	if n == 1 {
+		return endpoints[0], nil
+	}
+	i := randIntn(n)
+	j := randIntn(n - 1)
+	if j >= i {
+		j++
+	}
+	if endpoints[j].Pending() < endpoints[i].Pending() {
+		i = j
+	}
+	return endpoints[i], nil
+}
+
+type consistentHash struct {
+	key string
+
+	mutex sync.Mutex // protects following
+	addrs string     // the addresses the ring is built of
+	ring  []ringPoint
+}
+
+type ringPoint struct {
+	hash uint64
+	addr string
+}
+
+// ConsistentHash returns a balancer which picks the endpoint by hashing the
+// outgoing metadata value of key, so calls with the same value go to the
+// same server as long as it is up. Only a few values move to other servers
+// when servers come and go. Calls without the key are spread at random.
+func ConsistentHash(key string) Balancer {
+	return &consistentHash{key: key}
+}
+
+func (b *consistentHash) Pick(endpoints []Endpoint, info PickInfo) (Endpoint, error) {
+	md, _ := metadata.FromOutgoingContext(info.Ctx)
+	value := md.Get(b.key)
+	if value == "" {
+		return endpoints[randIntn(len(endpoints))], nil
+	}
+	addr := b.lookup(endpoints, hash(value))
+
+	// a pool has several connections to the address
+	var best Endpoint
+	for _, e := range endpoints {
+		if e.Addr() == addr && (best == nil || e.Pending() < best.Pending()) {
+			best = e
+		}
+	}
+	return best, nil
+}
+
+// lookup returns the address owning h on the ring of the endpoints
+func (b *consistentHash) lookup(endpoints []Endpoint, h uint64) string {
+	addrs := make([]string, 0, len(endpoints))
+	for _, e := range endpoints {
+		addrs = append(addrs, e.Addr())
+	}
+	sort.Strings(addrs)
+	key := strings.Join(addrs, ",")
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if key != b.addrs {
+		b.addrs = key
+		b.ring = b.ring[:0]
+		for i, addr := range addrs {
+			if i > 0 && addr == addrs[i-1] {
+				continue
+			}
+			for r := 0; r < hashReplicas; r++ {
+				b.ring = append(b.ring, ringPoint{hash(addr + "#" + strconv.Itoa(r)), addr})
+			}
+		}
+		sort.Slice(b.ring, func(i, j int) bool {
+			return b.ring[i].hash < b.ring[j].hash
+		})
+	}
+	i := sort.Search(len(b.ring), func(i int) bool {
+		return b.ring[i].hash >= h
+	})
+	if i == len(b.ring) {
+		i = 0
+	}
+	return b.ring[i].addr
+}
+
+func hash(s string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+	// FNV barely mixes similar strings like "host:port#1" and "host:port#2",
+	// the finalizer of MurmurHash3 spreads them over the ring
+	x := h.Sum64()
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
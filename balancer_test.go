@@ -0,0 +1,162 @@
+package tinyrpc
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zehuamama/tinyrpc/metadata"
+	"github.com/zehuamama/tinyrpc/resolver"
+	pb "github.com/zehuamama/tinyrpc/test.data/message"
+)
+
+type fakeEndpoint struct {
+	addr    string
+	pending int
+}
+
+func (e *fakeEndpoint) Addr() string { return e.addr }
+func (e *fakeEndpoint) Pending() int { return e.pending }
+
+func fakeEndpoints(pending ...int) []Endpoint {
+	endpoints := make([]Endpoint, len(pending))
+	for i, p := range pending {
+		endpoints[i] = &fakeEndpoint{addr: fmt.Sprintf("10.0.0.%d:8082", i), pending: p}
+	}
+	return endpoints
+}
+
+// pickCounts picks n times and counts the picks of every address
+func pickCounts(t *testing.T, b Balancer, endpoints []Endpoint, info PickInfo, n int) map[string]int {
+	counts := make(map[string]int)
+	for i := 0; i < n; i++ {
+		e, err := b.Pick(endpoints, info)
+		assert.Equal(t, nil, err)
+		counts[e.Addr()]++
+	}
+	return counts
+}
+
+// TestBalancers .
+func TestBalancers(t *testing.T) {
+	info := PickInfo{Ctx: context.Background(), ServiceMethod: "ArithService.Add"}
+	endpoints := fakeEndpoints(5, 0, 3)
+
+	counts := pickCounts(t, RoundRobin(), endpoints, info, 6)
+	assert.Equal(t, map[string]int{"10.0.0.0:8082": 2, "10.0.0.1:8082": 2, "10.0.0.2:8082": 2}, counts)
+
+	counts = pickCounts(t, Random(), endpoints, info, 300)
+	assert.Equal(t, 3, len(counts))
+
+	counts = pickCounts(t, LeastPending(), endpoints, info, 10)
+	assert.Equal(t, map[string]int{"10.0.0.1:8082": 10}, counts)
+	// ties are spread
+	counts = pickCounts(t, LeastPending(), fakeEndpoints(1, 1, 1), info, 300)
+	assert.Equal(t, 3, len(counts))
+
+	// the busiest endpoint loses every comparison
+	counts = pickCounts(t, P2C(), endpoints, info, 300)
+	assert.Equal(t, 0, counts["10.0.0.0:8082"])
+	assert.Less(t, counts["10.0.0.2:8082"], counts["10.0.0.1:8082"])
+	counts = pickCounts(t, P2C(), fakeEndpoints(0), info, 3)
+	assert.Equal(t, 3, counts["10.0.0.0:8082"])
+}
+
+// TestConsistentHash .
+func TestConsistentHash(t *testing.T) {
+	b := ConsistentHash("user")
+	endpoints := fakeEndpoints(0, 0, 0, 0)
+	pick := func(endpoints []Endpoint, user string) string {
+		ctx := metadata.NewOutgoingContext(context.Background(), metadata.Pairs("user", user))
+		e, err := b.Pick(endpoints, PickInfo{Ctx: ctx, ServiceMethod: "ArithService.Add"})
+		assert.Equal(t, nil, err)
+		return e.Addr()
+	}
+
+	owners := make(map[string]string)
+	used := make(map[string]bool)
+	for i := 0; i < 100; i++ {
+		user := fmt.Sprintf("user-%d", i)
+		owners[user] = pick(endpoints, user)
+		used[owners[user]] = true
+		// the same key goes to the same endpoint
+		assert.Equal(t, owners[user], pick(endpoints, user))
+	}
+	assert.Equal(t, 4, len(used))
+
+	// only the keys of a removed endpoint move
+	removed := endpoints[1].Addr()
+	remaining := append(append([]Endpoint{}, endpoints[:1]...), endpoints[2:]...)
+	for user, owner := range owners {
+		addr := pick(remaining, user)
+		if owner != removed {
+			assert.Equal(t, owner, addr)
+		} else {
+			assert.NotEqual(t, removed, addr)
+		}
+	}
+
+	// calls without the key are spread
+	counts := pickCounts(t, b, endpoints, PickInfo{Ctx: context.Background()}, 300)
+	assert.Equal(t, 4, len(counts))
+}
+
+// TestClientConn_Balancer .
+func TestClientConn_Balancer(t *testing.T) {
+	server1, addr1 := listenArith(t, "127.0.0.1:0")
+	defer server1.Close()
+	server2, addr2 := listenArith(t, "127.0.0.1:0")
+	defer server2.Close()
+
+	cc, err := DialResolver("tcp", "ArithService", resolver.NewStatic(addr1, addr2),
+		WithBalancer(RoundRobin()), WithPoolSize(2))
+	assert.Equal(t, nil, err)
+	defer cc.Close()
+
+	counts := make(map[string]int)
+	for i := 0; i < 8; i++ {
+		e, err := cc.pick(context.Background(), "ArithService.Add")
+		assert.Equal(t, nil, err)
+		counts[e.addr]++
+	}
+	assert.Equal(t, map[string]int{addr1: 4, addr2: 4}, counts)
+
+	// an endpoint is ejected after a transport error
+	cc.report(addr1, io.ErrUnexpectedEOF)
+	for i := 0; i < 4; i++ {
+		e, err := cc.pick(context.Background(), "ArithService.Add")
+		assert.Equal(t, nil, err)
+		assert.Equal(t, addr2, e.addr)
+	}
+	// unless no other is left
+	cc.report(addr2, io.ErrUnexpectedEOF)
+	counts = make(map[string]int)
+	for i := 0; i < 4; i++ {
+		e, err := cc.pick(context.Background(), "ArithService.Add")
+		assert.Equal(t, nil, err)
+		counts[e.addr]++
+	}
+	assert.Equal(t, 2, len(counts))
+
+	// and is back after a successful call
+	cc.report(addr1, nil)
+	for i := 0; i < 4; i++ {
+		e, err := cc.pick(context.Background(), "ArithService.Add")
+		assert.Equal(t, nil, err)
+		assert.Equal(t, addr1, e.addr)
+	}
+
+	// and once the ejection is over
+	assert.Eventually(t, func() bool {
+		e, err := cc.pick(context.Background(), "ArithService.Add")
+		return err == nil && e.addr == addr2
+	}, 2*time.Second, 10*time.Millisecond)
+
+	resp := &pb.ArithResponse{}
+	err = cc.Call("ArithService.Add", &pb.ArithRequest{A: 20, B: 5}, resp)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, float64(25), resp.C)
+}
@@ -0,0 +1,270 @@
+// Copyright 2022 <mzh.scnu@qq.com>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tinyrpc
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/zehuamama/tinyrpc/codes"
+	"github.com/zehuamama/tinyrpc/status"
+)
+
+const (
+	defaultBreakerMinRequests = 10
+	defaultBreakerWindow      = 10 * time.Second
+	defaultBreakerCoolDown    = 5 * time.Second
+)
+
+// ErrCircuitOpen is returned instead of sending a call while the circuit
+// breaker of its method or endpoint is open
+var ErrCircuitOpen = status.Error(codes.Unavailable, "tinyrpc: circuit breaker is open")
+
+// CircuitState is the state of a circuit breaker
+type CircuitState int
+
+const (
+	// CircuitClosed lets the calls through and counts their failures
+	CircuitClosed CircuitState = iota
+	// CircuitOpen fails the calls with ErrCircuitOpen until the cool-down is over
+	CircuitOpen
+	// CircuitHalfOpen lets a few trial calls through, the circuit is closed
+	// if they all succeed and opened again otherwise
+	CircuitHalfOpen
+)
+
+var circuitStateNames = map[CircuitState]string{
+	CircuitClosed:   "closed",
+	CircuitOpen:     "open",
+	CircuitHalfOpen: "half-open",
+}
+
+func (s CircuitState) String() string {
+	return circuitStateNames[s]
+}
+
+// CircuitBreakerPolicy configures the circuit breakers of a client. A Client
+// has a breaker per service method, a ClientConn has one per endpoint. The
+// breakers watch the unary calls, not the notifications and the streams.
+type CircuitBreakerPolicy struct {
+	// ConsecutiveFailures opens the circuit after as many failures in a row,
+	// it is not checked if it is 0
+	ConsecutiveFailures int
+	// FailureRate opens the circuit when the share of failed calls in the
+	// current window reaches it, once there were MinRequests calls. It is
+	// not checked if it is 0. MinRequests defaults to 10.
+	FailureRate float64
+	MinRequests int
+	// Window is how long the calls are counted before the counts are reset,
+	// it defaults to 10s
+	Window time.Duration
+	// CoolDown is how long the circuit stays open before trial calls are
+	// let through, it defaults to 5s
+	CoolDown time.Duration
+	// HalfOpenRequests is the number of trial calls which have to succeed
+	// to close the circuit, it defaults to 1
+	HalfOpenRequests int
+	// IsFailure classifies the errors of the calls, it defaults to transport
+	// errors, expired deadlines and errors with the Unavailable code
+	IsFailure func(err error) bool
+	// OnStateChange is called on every state transition with the service
+	// method or the endpoint address the circuit belongs to. It is called
+	// synchronously, so it should not block.
+	OnStateChange func(name string, from, to CircuitState)
+}
+
+// WithCircuitBreaker sets the circuit breaker policy of a client
+func WithCircuitBreaker(policy CircuitBreakerPolicy) Option {
+	return func(o *options) {
+		o.circuitBreaker = &policy
+	}
+}
+
+// isFailure is the default CircuitBreakerPolicy.IsFailure
+func isFailure(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+	if errors.Is(err, context.DeadlineExceeded) || IsTransportError(err) {
+		return true
+	}
+	code := status.Code(err)
+	return code == codes.Unavailable || code == codes.DeadlineExceeded
+}
+
+// breakers holds the circuit breakers of a client by name
+type breakers struct {
+	policy CircuitBreakerPolicy
+
+	mutex    sync.Mutex // protects breakers
+	breakers map[string]*breaker
+}
+
+// newBreakers returns nil if there is no policy
+func newBreakers(policy *CircuitBreakerPolicy) *breakers {
+	if policy == nil {
+		return nil
+	}
+	bs := &breakers{policy: *policy, breakers: make(map[string]*breaker)}
+	if bs.policy.MinRequests <= 0 {
+		bs.policy.MinRequests = defaultBreakerMinRequests
+	}
+	if bs.policy.Window <= 0 {
+		bs.policy.Window = defaultBreakerWindow
+	}
+	if bs.policy.CoolDown <= 0 {
+		bs.policy.CoolDown = defaultBreakerCoolDown
+	}
+	if bs.policy.HalfOpenRequests <= 0 {
+		bs.policy.HalfOpenRequests = 1
+	}
+	if bs.policy.IsFailure == nil {
+		bs.policy.IsFailure = isFailure
+	}
+	return bs
+}
+
+// get returns the breaker of name, it returns nil if bs is nil
+func (bs *breakers) get(name string) *breaker {
+	if bs == nil {
+		return nil
+	}
+	bs.mutex.Lock()
+	defer bs.mutex.Unlock()
+	b, ok := bs.breakers[name]
+	if !ok {
+		b = &breaker{name: name, policy: &bs.policy}
+		bs.breakers[name] = b
+	}
+	return b
+}
+
+// remove forgets the breaker of name
+func (bs *breakers) remove(name string) {
+	if bs == nil {
+		return
+	}
+	bs.mutex.Lock()
+	delete(bs.breakers, name)
+	bs.mutex.Unlock()
+}
+
+// breaker is the circuit breaker of a service method or an endpoint.
+// The calls are counted per generation, which starts with every state
+// transition and every window, so late results don't count twice.
+type breaker struct {
+	name   string
+	policy *CircuitBreakerPolicy
+
+	mutex       sync.Mutex // protects following
+	state       CircuitState
+	generation  uint64
+	expiry      time.Time // end of the window or of the cool-down
+	requests    int
+	failures    int
+	successes   int
+	consecutive int
+	trials      int // calls let through while half-open
+}
+
+// ready reports whether a call may be let through without admitting it
+func (b *breaker) ready() bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.update(time.Now())
+	return b.state != CircuitOpen &&
+		(b.state != CircuitHalfOpen || b.trials < b.policy.HalfOpenRequests)
+}
+
+// allow admits a call and returns its generation, or ErrCircuitOpen
+func (b *breaker) allow() (uint64, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.update(time.Now())
+	switch b.state {
+	case CircuitOpen:
+		return 0, ErrCircuitOpen
+	case CircuitHalfOpen:
+		if b.trials >= b.policy.HalfOpenRequests {
+			return 0, ErrCircuitOpen
+		}
+		b.trials++
+	}
+	b.requests++
+	return b.generation, nil
+}
+
+// done records the outcome of a call admitted in generation
+func (b *breaker) done(generation uint64, err error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.update(time.Now())
+	if generation != b.generation {
+		return
+	}
+	if errors.Is(err, context.Canceled) {
+		// the caller gave up, the call tells nothing about the server
+		b.requests--
+		if b.state == CircuitHalfOpen {
+			b.trials--
+		}
+		return
+	}
+	if !b.policy.IsFailure(err) {
+		b.successes++
+		b.consecutive = 0
+		if b.state == CircuitHalfOpen && b.successes >= b.policy.HalfOpenRequests {
+			b.setState(CircuitClosed, time.Now())
+		}
+		return
+	}
+	b.failures++
+	b.consecutive++
+	switch {
+	case b.state == CircuitHalfOpen,
+		b.policy.ConsecutiveFailures > 0 && b.consecutive >= b.policy.ConsecutiveFailures,
+		b.policy.FailureRate > 0 && b.requests >= b.policy.MinRequests &&
+			float64(b.failures) >= b.policy.FailureRate*float64(b.requests):
+		b.setState(CircuitOpen, time.Now())
+	}
+}
+
+// update ends the cool-down or the window when it is over
+func (b *breaker) update(now time.Time) {
+	if now.Before(b.expiry) {
+		return
+	}
+	switch b.state {
+	case CircuitOpen:
+		b.setState(CircuitHalfOpen, now)
+	case CircuitClosed:
+		b.reset(now)
+	}
+}
+
+func (b *breaker) setState(state CircuitState, now time.Time) {
+	from := b.state
+	b.state = state
+	b.reset(now)
+	if b.policy.OnStateChange != nil {
+		b.policy.OnStateChange(b.name, from, state)
+	}
+}
+
+// reset starts a new generation
+func (b *breaker) reset(now time.Time) {
+	b.generation++
+	b.requests, b.failures, b.successes, b.consecutive, b.trials = 0, 0, 0, 0, 0
+	switch b.state {
+	case CircuitClosed:
+		b.expiry = now.Add(b.policy.Window)
+	case CircuitOpen:
+		b.expiry = now.Add(b.policy.CoolDown)
+	case CircuitHalfOpen:
+		b.expiry = time.Time{}
+	}
+}
@@ -0,0 +1,248 @@
+package tinyrpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zehuamama/tinyrpc/codes"
+	"github.com/zehuamama/tinyrpc/resolver"
+	"github.com/zehuamama/tinyrpc/status"
+	pb "github.com/zehuamama/tinyrpc/test.data/message"
+)
+
+// DegradedService fails Get while it is degraded
+type DegradedService struct {
+	mutex    sync.Mutex
+	degraded bool
+	calls    int
+}
+
+func (s *DegradedService) Get(args *pb.ArithRequest, reply *pb.ArithResponse) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.calls++
+	if s.degraded {
+		return status.Error(codes.Unavailable, "degraded")
+	}
+	reply.C = args.A
+	return nil
+}
+
+func (s *DegradedService) Ping(args *pb.ArithRequest, reply *pb.ArithResponse) error {
+	return nil
+}
+
+func (s *DegradedService) set(degraded bool) {
+	s.mutex.Lock()
+	s.degraded = degraded
+	s.mutex.Unlock()
+}
+
+func (s *DegradedService) count() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.calls
+}
+
+// transitions records the state changes of circuit breakers
+type transitions struct {
+	mutex   sync.Mutex
+	changes []string
+}
+
+func (tr *transitions) record(name string, from, to CircuitState) {
+	tr.mutex.Lock()
+	tr.changes = append(tr.changes, fmt.Sprintf("%s: %v -> %v", name, from, to))
+	tr.mutex.Unlock()
+}
+
+func (tr *transitions) get() []string {
+	tr.mutex.Lock()
+	defer tr.mutex.Unlock()
+	return append([]string(nil), tr.changes...)
+}
+
+var errBroken = errors.New("broken")
+
+// TestBreaker .
+func TestBreaker(t *testing.T) {
+	tr := &transitions{}
+	bs := newBreakers(&CircuitBreakerPolicy{
+		ConsecutiveFailures: 3,
+		CoolDown:            50 * time.Millisecond,
+		OnStateChange:       tr.record,
+	})
+	b := bs.get("ArithService.Add")
+	call := func(err error) error {
+		generation, e := b.allow()
+		if e != nil {
+			return e
+		}
+		b.done(generation, err)
+		return nil
+	}
+
+	// successes and application errors reset the consecutive failures
+	assert.Equal(t, nil, call(errBroken))
+	assert.Equal(t, nil, call(errBroken))
+	assert.Equal(t, nil, call(nil))
+	assert.Equal(t, nil, call(errBroken))
+	assert.Equal(t, nil, call(status.Error(codes.NotFound, "not found")))
+	// calls the caller gave up on don't count
+	assert.Equal(t, nil, call(errBroken))
+	assert.Equal(t, nil, call(errBroken))
+	assert.Equal(t, nil, call(context.Canceled))
+	assert.Equal(t, CircuitClosed, b.state)
+	assert.Equal(t, nil, call(errBroken))
+	assert.Equal(t, CircuitOpen, b.state)
+	assert.Equal(t, ErrCircuitOpen, call(nil))
+	assert.Equal(t, false, b.ready())
+
+	// a single trial call is let through after the cool-down
+	time.Sleep(60 * time.Millisecond)
+	assert.Equal(t, true, b.ready())
+	generation, err := b.allow()
+	assert.Equal(t, nil, err)
+	_, err = b.allow()
+	assert.Equal(t, ErrCircuitOpen, err)
+	b.done(generation, errBroken)
+	assert.Equal(t, CircuitOpen, b.state)
+
+	time.Sleep(60 * time.Millisecond)
+	assert.Equal(t, nil, call(nil))
+	assert.Equal(t, CircuitClosed, b.state)
+
+	// late results of an older generation don't count
+	generation, err = b.allow()
+	assert.Equal(t, nil, err)
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, nil, call(errBroken))
+	}
+	b.done(generation, nil)
+	assert.Equal(t, CircuitOpen, b.state)
+
+	assert.Equal(t, []string{
+		"ArithService.Add: closed -> open",
+		"ArithService.Add: open -> half-open",
+		"ArithService.Add: half-open -> open",
+		"ArithService.Add: open -> half-open",
+		"ArithService.Add: half-open -> closed",
+		"ArithService.Add: closed -> open",
+	}, tr.get())
+}
+
+// TestBreaker_FailureRate .
+func TestBreaker_FailureRate(t *testing.T) {
+	bs := newBreakers(&CircuitBreakerPolicy{FailureRate: 0.5, MinRequests: 6, Window: 50 * time.Millisecond})
+	b := bs.get("ArithService.Add")
+	record := func(err error) {
+		generation, e := b.allow()
+		assert.Equal(t, nil, e)
+		b.done(generation, err)
+	}
+
+	for i := 0; i < 4; i++ {
+		record(nil)
+	}
+	record(errBroken)
+	record(errBroken)
+	assert.Equal(t, CircuitClosed, b.state)
+
+	// the counts are reset with every window
+	time.Sleep(60 * time.Millisecond)
+	for i := 0; i < 3; i++ {
+		record(nil)
+		record(errBroken)
+	}
+	assert.Equal(t, CircuitOpen, b.state)
+}
+
+// TestClient_CircuitBreaker .
+func TestClient_CircuitBreaker(t *testing.T) {
+	service := &DegradedService{degraded: true}
+	server := NewServer()
+	err := server.Register(service)
+	assert.Equal(t, nil, err)
+	tr := &transitions{}
+	client := serve(t, server, WithCircuitBreaker(CircuitBreakerPolicy{
+		ConsecutiveFailures: 2,
+		CoolDown:            50 * time.Millisecond,
+		OnStateChange:       tr.record,
+	}))
+
+	resp := &pb.ArithResponse{}
+	for i := 0; i < 2; i++ {
+		err = client.Call("DegradedService.Get", &pb.ArithRequest{A: 1}, resp)
+		assert.Equal(t, codes.Unavailable, status.Code(err))
+	}
+	// the calls of the method fail fast
+	err = client.Call("DegradedService.Get", &pb.ArithRequest{A: 1}, resp)
+	assert.Equal(t, ErrCircuitOpen, err)
+	assert.Equal(t, 2, service.count())
+	// the other methods are not affected
+	assert.Equal(t, nil, client.Call("DegradedService.Ping", &pb.ArithRequest{}, resp))
+
+	service.set(false)
+	assert.Eventually(t, func() bool {
+		return client.Call("DegradedService.Get", &pb.ArithRequest{A: 1}, resp) == nil
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, []string{
+		"DegradedService.Get: closed -> open",
+		"DegradedService.Get: open -> half-open",
+		"DegradedService.Get: half-open -> closed",
+	}, tr.get())
+}
+
+// TestClientConn_CircuitBreaker .
+func TestClientConn_CircuitBreaker(t *testing.T) {
+	var addrs []string
+	var services []*DegradedService
+	for i := 0; i < 2; i++ {
+		lis, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		service := &DegradedService{degraded: i == 0}
+		server := NewServer()
+		err = server.Register(service)
+		assert.Equal(t, nil, err)
+		go server.Serve(lis)
+		defer server.Close()
+		addrs = append(addrs, lis.Addr().String())
+		services = append(services, service)
+	}
+
+	tr := &transitions{}
+	cc, err := DialResolver("tcp", "DegradedService", resolver.NewStatic(addrs...),
+		WithCircuitBreaker(CircuitBreakerPolicy{
+			ConsecutiveFailures: 1,
+			CoolDown:            time.Minute,
+			OnStateChange:       tr.record,
+		}))
+	assert.Equal(t, nil, err)
+	defer cc.Close()
+
+	// the degraded endpoint is left out once its circuit is open
+	resp := &pb.ArithResponse{}
+	failed := 0
+	for i := 0; i < 10; i++ {
+		if cc.Call("DegradedService.Get", &pb.ArithRequest{A: 1}, resp) != nil {
+			failed++
+		}
+	}
+	assert.Equal(t, 1, failed)
+	assert.Equal(t, 1, services[0].count())
+	assert.Equal(t, 9, services[1].count())
+	assert.Equal(t, []string{addrs[0] + ": closed -> open"}, tr.get())
+
+	// calls fail fast once every circuit is open
+	services[1].set(true)
+	assert.NotEqual(t, nil, cc.Call("DegradedService.Get", &pb.ArithRequest{A: 1}, resp))
+	assert.Equal(t, ErrCircuitOpen, cc.Call("DegradedService.Get", &pb.ArithRequest{A: 1}, resp))
+}
@@ -5,25 +5,78 @@
 package tinyrpc
 
 import (
+	"context"
+	"crypto/tls"
+	"fmt"
 	"io"
+	"log"
 	"net/rpc"
+	"sync"
+	"time"
 
 	"github.com/zehuamama/tinyrpc/codec"
+	"github.com/zehuamama/tinyrpc/codes"
 	"github.com/zehuamama/tinyrpc/compressor"
+	"github.com/zehuamama/tinyrpc/header"
+	"github.com/zehuamama/tinyrpc/metadata"
 	"github.com/zehuamama/tinyrpc/serializer"
+	"github.com/zehuamama/tinyrpc/status"
 )
 
-// Client rpc client based on net/rpc implementation
+// Client rpc client, calls are multiplexed over a single connection
+// and matched with their responses by request ID
 type Client struct {
-	*rpc.Client
+	codec        codec.ClientCodec
+	serializer   serializer.Serializer
+	interceptor  UnaryClientInterceptor
+	streamWindow int
+	live         *liveness
+	breakers     *breakers // per service method, nil without a circuit breaker
+
+	reqMutex sync.Mutex // protects request
+	request  header.RequestHeader
+
+	mutex    sync.Mutex // protects following
+	seq      uint64
+	pending  map[uint64]*pendingCall
+	closing  bool  // user has called Close
+	shutdown bool  // server has told us to stop
+	draining bool  // server is shutting down, no new calls are sent
+	fatal    error // why the connection has been closed by the client, if it has
+
+	done chan struct{} // closed when the connection is gone
 }
 
 //Option provides options for rpc
 type Option func(o *options)
 
 type options struct {
-	compressType compressor.CompressType
-	serializer   serializer.Serializer
+	compressType       compressor.CompressType
+	serializer         serializer.Serializer
+	serverInterceptors []UnaryServerInterceptor
+	clientInterceptors []UnaryClientInterceptor
+	limits             codec.Limits
+	poolSize           int
+	backoff            backoff
+	retryPolicy        RetryPolicy
+	idempotent         map[string]bool
+	streamWindow       int
+	maxHandlers        int
+	maxConnInFlight    int
+	maxConnQueued      int
+	queueOnLimit       bool
+	rateLimits         rateLimits
+	tlsConfig          *tls.Config
+	credentials        Credentials
+	verifier           Verifier
+	keepaliveInterval  time.Duration
+	keepaliveTimeout   time.Duration
+	idleTimeout        time.Duration
+	balancer           Balancer
+	circuitBreaker     *CircuitBreakerPolicy
+	hedging            *HedgingPolicy
+	healthService      string
+	healthInterval     time.Duration
 }
 
 // WithCompress set client compression format
@@ -40,6 +93,24 @@ func WithSerializer(serializer serializer.Serializer) Option {
 	}
 }
 
+// WithMaxHeaderSize sets the largest frame header the client or the server
+// accepts, a larger one fails with *codec.TooLargeError and the connection
+// is closed. The default is codec.DefaultMaxHeaderSize.
+func WithMaxHeaderSize(n int) Option {
+	return func(o *options) {
+		o.limits.MaxHeaderSize = uint64(n)
+	}
+}
+
+// WithMaxMessageSize sets the largest (compressed) message body the client
+// or the server accepts, a larger one fails with *codec.TooLargeError and
+// the connection is closed. The default is codec.DefaultMaxMessageSize.
+func WithMaxMessageSize(n int) Option {
+	return func(o *options) {
+		o.limits.MaxMessageSize = uint64(n)
+	}
+}
+
 // NewClient Create a new rpc client
 func NewClient(conn io.ReadWriteCloser, opts ...Option) *Client {
 	options := options{
@@ -49,16 +120,461 @@ func NewClient(conn io.ReadWriteCloser, opts ...Option) *Client {
 	for _, option := range opts {
 		option(&options)
 	}
-	return &Client{rpc.NewClientWithCodec(
-		codec.NewClientCodec(conn, options.compressType, options.serializer))}
+	return newClient(codec.NewClientWithSigner(conn, options.compressType, options.serializer,
+		options.limits, signFunc(options.credentials)), options)
+}
+
+// NewClientWithCodec is like NewClient but uses the codec to send requests and
+// read responses, the compress, size and credentials options only apply to tinyrpc codecs
+func NewClientWithCodec(cc codec.ClientCodec, opts ...Option) *Client {
+	options := options{
+		serializer: serializer.Proto,
+	}
+	for _, option := range opts {
+		option(&options)
+	}
+	return newClient(cc, options)
+}
+
+func newClient(cc codec.ClientCodec, options options) *Client {
+	client := &Client{
+		codec:        cc,
+		serializer:   options.serializer,
+		interceptor:  chainUnaryClientInterceptors(options.clientInterceptors),
+		streamWindow: streamWindow(options.streamWindow),
+		live:         newLiveness(),
+		breakers:     newBreakers(options.circuitBreaker),
+		pending:      make(map[uint64]*pendingCall),
+		done:         make(chan struct{}),
+	}
+	go client.input()
+	if options.keepaliveInterval > 0 {
+		go client.keepalive(options.keepaliveInterval, options.keepaliveTimeout)
+	}
+	return client
 }
 
 // Call synchronously calls the rpc function
 func (c *Client) Call(serviceMethod string, args interface{}, reply interface{}) error {
-	return c.Client.Call(serviceMethod, args, reply)
+	return c.CallContext(context.Background(), serviceMethod, args, reply)
+}
+
+// CallContext synchronously calls the rpc function and waits at most until ctx is done.
+// If ctx is done first, the call is abandoned, ctx.Err() is returned and the late
+// response is discarded, reply is never written after CallContext returns.
+func (c *Client) CallContext(ctx context.Context, serviceMethod string, args interface{}, reply interface{}) error {
+	if c.interceptor == nil {
+		return c.invoke(ctx, serviceMethod, args, reply)
+	}
+	return c.interceptor(ctx, serviceMethod, args, reply, c.invoke)
+}
+
+// invoke sends the call through the circuit breaker of its method,
+// it is the last UnaryInvoker of the chain
+func (c *Client) invoke(ctx context.Context, serviceMethod string, args interface{}, reply interface{}) error {
+	b := c.breakers.get(serviceMethod)
+	if b == nil {
+		return c.roundTrip(ctx, serviceMethod, args, reply)
+	}
+	generation, err := b.allow()
+	if err != nil {
+		return err
+	}
+	err = c.roundTrip(ctx, serviceMethod, args, reply)
+	b.done(generation, err)
+	return err
+}
+
+// roundTrip sends the call and waits for its reply
+func (c *Client) roundTrip(ctx context.Context, serviceMethod string, args interface{}, reply interface{}) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	call := &rpc.Call{
+		ServiceMethod: serviceMethod,
+		Args:          args,
+		Reply:         reply,
+		Done:          make(chan *rpc.Call, 1),
+	}
+	seq, ok := c.send(ctx, call)
+	select {
+	case <-call.Done:
+		return call.Error
+	case <-ctx.Done():
+		if ok && c.cancel(seq) {
+			go c.sendCancel(seq)
+			return ctx.Err()
+		}
+		// the response is being read into reply right now
+		<-call.Done
+		return call.Error
+	}
 }
 
 // AsyncCall asynchronously calls the rpc function and returns a channel of *rpc.Call
 func (c *Client) AsyncCall(serviceMethod string, args interface{}, reply interface{}) chan *rpc.Call {
 	return c.Go(serviceMethod, args, reply, nil).Done
 }
+
+// Go invokes the function asynchronously. It returns the rpc.Call structure representing
+// the invocation. The done channel will signal when the call is complete by returning
+// the same Call object. If done is nil, Go will allocate a new channel.
+// If non-nil, done must be buffered or Go will deliberately crash.
+// When the client has interceptors, they run on a new goroutine.
+func (c *Client) Go(serviceMethod string, args interface{}, reply interface{}, done chan *rpc.Call) *rpc.Call {
+	call := &rpc.Call{
+		ServiceMethod: serviceMethod,
+		Args:          args,
+		Reply:         reply,
+	}
+	if done == nil {
+		done = make(chan *rpc.Call, 10) // buffered.
+	} else if cap(done) == 0 {
+		log.Panic("rpc: done channel is unbuffered")
+	}
+	call.Done = done
+	if c.interceptor == nil && c.breakers == nil {
+		c.send(context.Background(), call)
+		return call
+	}
+	go func() {
+		call.Error = c.CallContext(context.Background(), serviceMethod, args, reply)
+		callDone(call)
+	}()
+	return call
+}
+
+// Notify calls the rpc function without waiting for it, the server sends
+// no response, so the reply and any error of the service are dropped.
+// It returns once the request has been written.
+func (c *Client) Notify(serviceMethod string, args interface{}) error {
+	return c.NotifyContext(context.Background(), serviceMethod, args)
+}
+
+// NotifyContext is Notify with the metadata and the deadline of ctx sent
+// along, the client interceptors see a nil reply
+func (c *Client) NotifyContext(ctx context.Context, serviceMethod string, args interface{}) error {
+	if c.interceptor == nil {
+		return c.notify(ctx, serviceMethod, args, nil)
+	}
+	return c.interceptor(ctx, serviceMethod, args, nil, c.notify)
+}
+
+// notify writes a one-way request, it is the last UnaryInvoker of the chain of Notify
+func (c *Client) notify(ctx context.Context, serviceMethod string, args interface{}, _ interface{}) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	c.reqMutex.Lock()
+	defer c.reqMutex.Unlock()
+
+	c.mutex.Lock()
+	if c.shutdown || c.closing || c.draining {
+		c.mutex.Unlock()
+		return rpc.ErrShutdown
+	}
+	// one-way requests need an ID of their own since the server
+	// tracks the requests it is handling by ID
+	seq := c.seq
+	c.seq++
+	c.mutex.Unlock()
+
+	c.request.ResetHeader()
+	c.request.ID = seq
+	c.request.Method = serviceMethod
+	c.request.Flags = header.FlagOneWay
+	if md, ok := metadata.FromOutgoingContext(ctx); ok {
+		c.request.Metadata = md
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		c.request.Timeout = time.Until(deadline)
+	}
+	return c.codec.WriteRequest(&c.request, args)
+}
+
+// Close closes the underlying connection, pending calls fail with rpc.ErrShutdown
+func (c *Client) Close() error {
+	c.mutex.Lock()
+	if c.closing {
+		c.mutex.Unlock()
+		return rpc.ErrShutdown
+	}
+	c.closing = true
+	c.mutex.Unlock()
+	return c.codec.Close()
+}
+
+// send registers the call and writes its request, it returns the request ID
+// and whether the call has been registered as pending. The deadline of ctx
+// is sent along so the server can give up when the client does.
+func (c *Client) send(ctx context.Context, call *rpc.Call) (uint64, bool) {
+	return c.sendStream(ctx, call, nil)
+}
+
+// sendStream is send for a call whose response is a stream of messages
+func (c *Client) sendStream(ctx context.Context, call *rpc.Call, stream *ClientStream) (uint64, bool) {
+	c.reqMutex.Lock()
+	defer c.reqMutex.Unlock()
+
+	c.mutex.Lock()
+	if c.shutdown || c.closing || c.draining {
+		c.mutex.Unlock()
+		call.Error = rpc.ErrShutdown
+		callDone(call)
+		return 0, false
+	}
+	seq := c.seq
+	c.seq++
+	c.pending[seq] = &pendingCall{
+		Call:   call,
+		md:     responseMetadataCapture(ctx),
+		stream: stream,
+		hedge:  hedgeFromContext(ctx),
+	}
+	c.mutex.Unlock()
+
+	c.request.ResetHeader()
+	c.request.ID = seq
+	c.request.Method = call.ServiceMethod
+	if stream != nil {
+		c.request.Window = uint32(c.streamWindow - header.InitialWindow)
+	}
+	if md, ok := metadata.FromOutgoingContext(ctx); ok {
+		c.request.Metadata = md
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		c.request.Timeout = time.Until(deadline)
+		if c.request.Timeout <= 0 {
+			c.remove(seq)
+			call.Error = context.DeadlineExceeded
+			callDone(call)
+			return seq, true
+		}
+	}
+	if err := c.codec.WriteRequest(&c.request, call.Args); err != nil {
+		if pc := c.remove(seq); pc != nil {
+			pc.Error = err
+			callDone(pc.Call)
+		}
+	}
+	return seq, true
+}
+
+// sendCancel tells the server that the call with the given
+// request ID has been abandoned, errors are ignored
+func (c *Client) sendCancel(seq uint64) {
+	c.writeStreamFrame(seq, header.FlagCancel, 0, nil)
+}
+
+// writeStreamFrame writes a frame of the call with the given request ID
+func (c *Client) writeStreamFrame(seq uint64, flags uint32, window int, m interface{}) error {
+	c.reqMutex.Lock()
+	defer c.reqMutex.Unlock()
+	c.request.ResetHeader()
+	c.request.ID = seq
+	c.request.Flags = flags
+	c.request.Window = uint32(window)
+	return c.codec.WriteRequest(&c.request, m)
+}
+
+// cancel forgets the pending call with the given request ID, it reports
+// whether the call was still waiting for its response
+func (c *Client) cancel(seq uint64) bool {
+	return c.remove(seq) != nil
+}
+
+func (c *Client) stream(seq uint64) *ClientStream {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if call := c.pending[seq]; call != nil {
+		return call.stream
+	}
+	return nil
+}
+
+func (c *Client) remove(seq uint64) *pendingCall {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	call := c.pending[seq]
+	delete(c.pending, seq)
+	return call
+}
+
+// input reads responses and completes the matching pending calls
+func (c *Client) input() {
+	var err error
+	var response header.ResponseHeader
+	for err == nil {
+		response.ResetHeader()
+		err = c.codec.ReadResponseHeader(&response)
+		if err != nil {
+			break
+		}
+		c.live.touch()
+		if response.Flags&(header.FlagPing|header.FlagPong) != 0 {
+			if response.Flags&header.FlagPing != 0 {
+				go c.writeStreamFrame(response.ID, header.FlagPong, 0, nil)
+			}
+			err = c.codec.ReadResponseBody(&response, nil)
+			continue
+		}
+		if response.Flags&header.FlagGoAway != 0 {
+			// the calls in flight are still answered, the connection
+			// is closed once they are so the server needn't wait
+			c.mutex.Lock()
+			c.draining = true
+			c.mutex.Unlock()
+			go c.retire()
+			err = c.codec.ReadResponseBody(&response, nil)
+			continue
+		}
+		if response.Flags&header.FlagWindowUpdate != 0 {
+			if stream := c.stream(response.ID); stream != nil {
+				stream.out.grant(int(response.Window))
+			}
+			err = c.codec.ReadResponseBody(&response, nil)
+			continue
+		}
+		if response.Flags&header.FlagStream != 0 {
+			// a message of a stream, it is decoded by Recv
+			var payload []byte
+			payload, err = c.codec.ReadResponsePayload(&response)
+			if stream := c.stream(response.ID); stream != nil && err == nil {
+				stream.push(payload)
+			}
+			continue
+		}
+		call := c.remove(response.ID)
+		if call != nil && call.hedge != nil {
+			if response.Error != "" && hedgeRetryable(responseError(&response)) {
+				// the other copies of the call may still be answered,
+				// the error does not claim the reply
+				call.Error = responseError(&response)
+				err = c.codec.ReadResponseBody(&response, nil)
+				callDone(call.Call)
+				continue
+			}
+			if !call.hedge.claim() {
+				// another copy of the call has been answered first,
+				// the reply belongs to it
+				call.Error = context.Canceled
+				err = c.codec.ReadResponseBody(&response, nil)
+				callDone(call.Call)
+				continue
+			}
+		}
+		if call != nil && call.md != nil {
+			*call.md = metadata.MD(response.Metadata)
+		}
+
+		switch {
+		case call == nil:
+			// We've got no pending call. That usually means the caller
+			// gave up waiting or WriteRequest partially failed, so the
+			// response is discarded.
+			err = c.codec.ReadResponseBody(&response, nil)
+		case response.Error != "":
+			call.Error = responseError(&response)
+			err = c.codec.ReadResponseBody(&response, nil)
+			callDone(call.Call)
+		default:
+			err = c.codec.ReadResponseBody(&response, call.Reply)
+			if err != nil {
+				call.Error = fmt.Errorf("reading body %w", err)
+			}
+			callDone(call.Call)
+		}
+	}
+	// Terminate pending calls.
+	c.reqMutex.Lock()
+	c.mutex.Lock()
+	c.shutdown = true
+	closing := c.closing
+	if c.fatal != nil {
+		err = c.fatal
+	} else if err == io.EOF {
+		if closing {
+			err = rpc.ErrShutdown
+		} else {
+			err = io.ErrUnexpectedEOF
+		}
+	}
+	for _, call := range c.pending {
+		call.Error = err
+		callDone(call.Call)
+	}
+	c.pending = make(map[uint64]*pendingCall)
+	c.mutex.Unlock()
+	c.reqMutex.Unlock()
+	if !closing {
+		// the stream can't be trusted anymore, e.g. after an oversize frame
+		c.codec.Close()
+	}
+	close(c.done)
+}
+
+// available reports whether new calls can be sent
+func (c *Client) available() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return !c.shutdown && !c.closing && !c.draining
+}
+
+// outstanding returns the number of calls waiting for a response
+func (c *Client) outstanding() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return len(c.pending)
+}
+
+// retire stops sending new calls and closes the connection once
+// the pending calls are done
+func (c *Client) retire() {
+	c.mutex.Lock()
+	c.draining = true
+	c.mutex.Unlock()
+
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+	for {
+		c.mutex.Lock()
+		idle := len(c.pending) == 0
+		c.mutex.Unlock()
+		if idle {
+			c.Close()
+			return
+		}
+		select {
+		case <-ticker.C:
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// responseError returns the error of a failed response, a status error
+// if the server sent a status code, rpc.ServerError otherwise
+func responseError(h *header.ResponseHeader) error {
+	if h.Code == 0 {
+		return rpc.ServerError(h.Error)
+	}
+	return status.New(codes.Code(h.Code), h.Error).WithDetails(h.Details).Err()
+}
+
+// pendingCall is a call waiting for its response
+type pendingCall struct {
+	*rpc.Call
+	md     *metadata.MD  // receives the response metadata, may be nil
+	stream *ClientStream // receives the messages of a stream, may be nil
+	hedge  *hedgeAttempt // the copy of a hedged call the call is, may be nil
+}
+
+func callDone(call *rpc.Call) {
+	select {
+	case call.Done <- call:
+		// ok
+	default:
+		// We don't want to block here. It is the caller's responsibility to make
+		// sure the channel has enough buffer space. See comment in Go().
+	}
+}
@@ -16,6 +16,24 @@ import (
 	"github.com/zehuamama/tinyrpc/serializer"
 )
 
+// ClientCodec implements the client side of the tinyrpc protocol. Unlike
+// rpc.ClientCodec it works with complete tinyrpc headers, so the caller
+// decides which request IDs are outstanding.
+type ClientCodec interface {
+	// WriteRequest fills in the body related fields of h and writes
+	// the request header and body to the io stream
+	WriteRequest(h *header.RequestHeader, param interface{}) error
+	// ReadResponseHeader reads the next response header into h
+	ReadResponseHeader(h *header.ResponseHeader) error
+	// ReadResponseBody reads the body described by h into param,
+	// the body is discarded when param is nil
+	ReadResponseBody(h *header.ResponseHeader, param interface{}) error
+	// ReadResponsePayload reads the body described by h, it is checked and
+	// decompressed but not deserialized, so it can be decoded later
+	ReadResponsePayload(h *header.ResponseHeader) ([]byte, error)
+	Close() error
+}
+
 type clientCodec struct {
 	r io.Reader
 	w io.Writer
@@ -23,14 +41,25 @@ type clientCodec struct {
 
 	compressor compressor.CompressType // rpc compress type(raw,gzip,snappy,zlib)
 	serializer serializer.Serializer
-	response   header.ResponseHeader // rpc response header
-	mutex      sync.Mutex            // protect pending map
-	pending    map[uint64]string
+	limits     Limits
+	sign       SignFunc // nil if requests are not signed
 }
 
-// NewClientCodec Create a new client codec
-func NewClientCodec(conn io.ReadWriteCloser,
-	compressType compressor.CompressType, serializer serializer.Serializer) rpc.ClientCodec {
+// SignFunc signs a request, it is called once the body related fields of h
+// are filled in with the compressed body which follows h, so the credentials
+// it adds to h can cover them
+type SignFunc func(h *header.RequestHeader, body []byte) error
+
+// NewClient Create a new tinyrpc client codec
+func NewClient(conn io.ReadWriteCloser,
+	compressType compressor.CompressType, serializer serializer.Serializer, limits Limits) ClientCodec {
+
+	return NewClientWithSigner(conn, compressType, serializer, limits, nil)
+}
+
+// NewClientWithSigner is like NewClient but every request is passed to sign right before it is written
+func NewClientWithSigner(conn io.ReadWriteCloser, compressType compressor.CompressType,
+	serializer serializer.Serializer, limits Limits, sign SignFunc) ClientCodec {
 
 	return &clientCodec{
 		r:          bufio.NewReader(conn),
@@ -38,37 +67,36 @@ func NewClientCodec(conn io.ReadWriteCloser,
 		c:          conn,
 		compressor: compressType,
 		serializer: serializer,
-		pending:    make(map[uint64]string),
+		limits:     limits,
+		sign:       sign,
 	}
 }
 
 // WriteRequest Write the rpc request header and body to the io stream
-func (c *clientCodec) WriteRequest(r *rpc.Request, param interface{}) error {
-	c.mutex.Lock()
-	c.pending[r.Seq] = r.ServiceMethod
-	c.mutex.Unlock()
-
+func (c *clientCodec) WriteRequest(h *header.RequestHeader, param interface{}) error {
 	if _, ok := compressor.Compressors[c.compressor]; !ok {
 		return NotFoundCompressorError
 	}
-	reqBody, err := c.serializer.Marshal(param)
-	if err != nil {
-		return err
+	var reqBody []byte
+	var err error
+	if param != nil {
+		reqBody, err = c.serializer.Marshal(param)
+		if err != nil {
+			return err
+		}
 	}
 	compressedReqBody, err := compressor.Compressors[c.compressor].Zip(reqBody)
 	if err != nil {
 		return err
 	}
-	h := header.RequestPool.Get().(*header.RequestHeader)
-	defer func() {
-		h.ResetHeader()
-		header.RequestPool.Put(h)
-	}()
-	h.ID = r.Seq
-	h.Method = r.ServiceMethod
 	h.RequestLen = uint32(len(compressedReqBody))
 	h.CompressType = compressor.CompressType(c.compressor)
 	h.Checksum = crc32.ChecksumIEEE(compressedReqBody)
+	if c.sign != nil {
+		if err := c.sign(h, compressedReqBody); err != nil {
+			return err
+		}
+	}
 
 	if err := sendFrame(c.w, h.Marshal()); err != nil {
 		return err
@@ -77,65 +105,173 @@ func (c *clientCodec) WriteRequest(r *rpc.Request, param interface{}) error {
 		return err
 	}
 
-	c.w.(*bufio.Writer).Flush()
-	return nil
+	return c.w.(*bufio.Writer).Flush()
 }
 
 // ReadResponseHeader read the rpc response header from the io stream
-func (c *clientCodec) ReadResponseHeader(r *rpc.Response) error {
-	c.response.ResetHeader()
-	data, err := recvFrame(c.r)
-	if err != nil {
-		return err
-	}
-	err = c.response.Unmarshal(data)
+func (c *clientCodec) ReadResponseHeader(h *header.ResponseHeader) error {
+	data, err := recvFrame(c.r, c.limits.maxHeaderSize())
 	if err != nil {
 		return err
 	}
-	c.mutex.Lock()
-	r.Seq = c.response.ID
-	r.Error = c.response.Error
-	r.ServiceMethod = c.pending[r.Seq]
-	delete(c.pending, r.Seq)
-	c.mutex.Unlock()
-	return nil
+	return h.Unmarshal(data)
 }
 
 // ReadResponseBody read the rpc response body from the io stream
-func (c *clientCodec) ReadResponseBody(param interface{}) error {
+func (c *clientCodec) ReadResponseBody(h *header.ResponseHeader, param interface{}) error {
+	if err := c.limits.checkMessageSize(h.ResponseLen); err != nil {
+		return err
+	}
 	if param == nil {
-		if c.response.ResponseLen != 0 {
-			if err := read(c.r, make([]byte, c.response.ResponseLen)); err != nil {
+		if h.ResponseLen != 0 {
+			if err := read(c.r, make([]byte, h.ResponseLen)); err != nil {
 				return err
 			}
 		}
 		return nil
 	}
 
-	respBody := make([]byte, c.response.ResponseLen)
-	err := read(c.r, respBody)
+	resp, err := c.ReadResponsePayload(h)
 	if err != nil {
 		return err
 	}
+	return c.serializer.Unmarshal(resp, param)
+}
 
-	if c.response.Checksum != 0 {
-		if crc32.ChecksumIEEE(respBody) != c.response.Checksum {
-			return UnexpectedChecksumError
-		}
+// ReadResponsePayload read the rpc response body from the io stream without deserializing it
+func (c *clientCodec) ReadResponsePayload(h *header.ResponseHeader) ([]byte, error) {
+	if err := c.limits.checkMessageSize(h.ResponseLen); err != nil {
+		return nil, err
+	}
+	respBody := make([]byte, h.ResponseLen)
+	err := read(c.r, respBody)
+	if err != nil {
+		return nil, err
 	}
 
-	if c.response.GetCompressType() != c.compressor {
-		return CompressorTypeMismatchError
+	if h.Checksum != 0 {
+		if crc32.ChecksumIEEE(respBody) != h.Checksum {
+			return nil, UnexpectedChecksumError
+		}
 	}
 
-	resp, err := compressor.Compressors[c.response.GetCompressType()].Unzip(respBody)
-	if err != nil {
-		return err
+	if h.GetCompressType() != c.compressor {
+		return nil, CompressorTypeMismatchError
 	}
 
-	return c.serializer.Unmarshal(resp, param)
+	return compressor.Compressors[h.GetCompressType()].Unzip(respBody)
 }
 
 func (c *clientCodec) Close() error {
 	return c.c.Close()
 }
+
+// rpcClientCodec adapts ClientCodec to rpc.ClientCodec
+type rpcClientCodec struct {
+	codec    ClientCodec
+	response header.ResponseHeader // rpc response header
+	mutex    sync.Mutex            // protect pending map
+	pending  map[uint64]string
+}
+
+// NewClientCodec Create a new client codec which can be used by net/rpc
+func NewClientCodec(conn io.ReadWriteCloser,
+	compressType compressor.CompressType, serializer serializer.Serializer) rpc.ClientCodec {
+
+	return &rpcClientCodec{
+		codec:   NewClient(conn, compressType, serializer, Limits{}),
+		pending: make(map[uint64]string),
+	}
+}
+
+// WriteRequest Write the rpc request header and body to the io stream
+func (c *rpcClientCodec) WriteRequest(r *rpc.Request, param interface{}) error {
+	c.mutex.Lock()
+	c.pending[r.Seq] = r.ServiceMethod
+	c.mutex.Unlock()
+
+	h := header.RequestPool.Get().(*header.RequestHeader)
+	defer func() {
+		h.ResetHeader()
+		header.RequestPool.Put(h)
+	}()
+	h.ID = r.Seq
+	h.Method = r.ServiceMethod
+	return c.codec.WriteRequest(h, param)
+}
+
+// ReadResponseHeader read the rpc response header from the io stream
+func (c *rpcClientCodec) ReadResponseHeader(r *rpc.Response) error {
+	c.response.ResetHeader()
+	if err := c.codec.ReadResponseHeader(&c.response); err != nil {
+		return err
+	}
+	c.mutex.Lock()
+	r.Seq = c.response.ID
+	r.Error = c.response.Error
+	r.ServiceMethod = c.pending[r.Seq]
+	delete(c.pending, r.Seq)
+	c.mutex.Unlock()
+	return nil
+}
+
+// ReadResponseBody read the rpc response body from the io stream
+func (c *rpcClientCodec) ReadResponseBody(param interface{}) error {
+	return c.codec.ReadResponseBody(&c.response, param)
+}
+
+func (c *rpcClientCodec) Close() error {
+	return c.codec.Close()
+}
+
+// clientCodecAdapter adapts rpc.ClientCodec to ClientCodec
+type clientCodecAdapter struct {
+	codec rpc.ClientCodec
+}
+
+// FromRPCClientCodec adapts a net/rpc client codec, like jsonrpc.NewClientCodec,
+// so that it can be used by tinyrpc.Client. The net/rpc wire format has no
+// room for metadata, timeouts, status codes, streams, pings or one-way calls, these are not supported then.
+func FromRPCClientCodec(cc rpc.ClientCodec) ClientCodec {
+	return &clientCodecAdapter{codec: cc}
+}
+
+// WriteRequest Write the rpc request header and body to the io stream
+func (c *clientCodecAdapter) WriteRequest(h *header.RequestHeader, param interface{}) error {
+	if h.Method == "" && h.Flags == header.FlagCancel {
+		// the server can not be told, the late response is discarded
+		return nil
+	}
+	if h.Flags != 0 {
+		return StreamNotSupportedError
+	}
+	return c.codec.WriteRequest(&rpc.Request{ServiceMethod: h.Method, Seq: h.ID}, param)
+}
+
+// ReadResponseHeader read the rpc response header from the io stream
+func (c *clientCodecAdapter) ReadResponseHeader(h *header.ResponseHeader) error {
+	r := rpc.Response{}
+	if err := c.codec.ReadResponseHeader(&r); err != nil {
+		return err
+	}
+	h.ID = r.Seq
+	h.Error = r.Error
+	return nil
+}
+
+// ReadResponseBody read the rpc response body from the io stream
+func (c *clientCodecAdapter) ReadResponseBody(h *header.ResponseHeader, param interface{}) error {
+	return c.codec.ReadResponseBody(param)
+}
+
+// ReadResponsePayload is not supported since the body is decoded by the net/rpc codec
+func (c *clientCodecAdapter) ReadResponsePayload(h *header.ResponseHeader) ([]byte, error) {
+	if err := c.codec.ReadResponseBody(nil); err != nil {
+		return nil, err
+	}
+	return nil, StreamNotSupportedError
+}
+
+func (c *clientCodecAdapter) Close() error {
+	return c.codec.Close()
+}
@@ -0,0 +1,36 @@
+// Copyright 2022 <mzh.scnu@qq.com>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package codec
+
+import (
+	"net"
+	"net/rpc"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zehuamama/tinyrpc/compressor"
+	"github.com/zehuamama/tinyrpc/serializer"
+	pb "github.com/zehuamama/tinyrpc/test.data/message"
+)
+
+// TestNetRPCCodec the codecs can still be used with net/rpc
+func TestNetRPCCodec(t *testing.T) {
+	server := rpc.NewServer()
+	err := server.Register(new(pb.ArithService))
+	assert.Equal(t, nil, err)
+
+	cliConn, svrConn := net.Pipe()
+	go server.ServeCodec(NewServerCodec(svrConn, serializer.Proto))
+	client := rpc.NewClientWithCodec(NewClientCodec(cliConn, compressor.Gzip, serializer.Proto))
+	defer client.Close()
+
+	reply := &pb.ArithResponse{}
+	err = client.Call("ArithService.Mul", &pb.ArithRequest{A: 20, B: 5}, reply)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, float64(100), reply.C)
+
+	err = client.Call("ArithService.Div", &pb.ArithRequest{A: 20, B: 0}, reply)
+	assert.Equal(t, rpc.ServerError("divided is zero"), err)
+}
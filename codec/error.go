@@ -4,11 +4,30 @@
 
 package codec
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+)
 
 var (
 	InvalidSequenceError        = errors.New("invalid sequence number in response")
 	UnexpectedChecksumError     = errors.New("unexpected checksum")
 	NotFoundCompressorError     = errors.New("not found compressor")
 	CompressorTypeMismatchError = errors.New("request and response Compressor type mismatch")
+	InvalidMagicError           = errors.New("invalid magic number, not a tinyrpc frame")
+	UnsupportedVersionError     = errors.New("unsupported tinyrpc protocol version")
+	StreamNotSupportedError     = errors.New("streams are not supported by net/rpc codecs")
 )
+
+// TooLargeError is returned when a frame header or a message body is larger
+// than the limit of the codec, the connection has to be closed afterwards
+// since the oversize data is not read
+type TooLargeError struct {
+	What  string // "header" or "message"
+	Size  uint64
+	Limit uint64
+}
+
+func (e *TooLargeError) Error() string {
+	return fmt.Sprintf("tinyrpc: %s size %d exceeds the limit %d", e.What, e.Size, e.Limit)
+}
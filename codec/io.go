@@ -7,10 +7,57 @@ package codec
 import (
 	"encoding/binary"
 	"io"
-	"net"
+
+	"github.com/zehuamama/tinyrpc/header"
+)
+
+const (
+	// DefaultMaxHeaderSize is the default limit of the header size
+	DefaultMaxHeaderSize = 64 << 10
+	// DefaultMaxMessageSize is the default limit of the (compressed) body size
+	DefaultMaxMessageSize = 4 << 20
 )
 
+// Limits bounds the size of the data a codec reads, it keeps a corrupt or
+// malicious frame from making the codec allocate huge buffers
+type Limits struct {
+	MaxHeaderSize  uint64 // 0 means DefaultMaxHeaderSize
+	MaxMessageSize uint64 // 0 means DefaultMaxMessageSize
+}
+
+func (l Limits) maxHeaderSize() uint64 {
+	if l.MaxHeaderSize == 0 {
+		return DefaultMaxHeaderSize
+	}
+	return l.MaxHeaderSize
+}
+
+// checkMessageSize checks the size of a body before it is read
+func (l Limits) checkMessageSize(size uint32) error {
+	limit := l.MaxMessageSize
+	if limit == 0 {
+		limit = DefaultMaxMessageSize
+	}
+	if uint64(size) > limit {
+		return &TooLargeError{What: "message", Size: uint64(size), Limit: limit}
+	}
+	return nil
+}
+
+// sendFrame writes a frame, it looks like:
+// +-------+---------+---------+------+
+// | Magic | Version |   Size  | Data |
+// +-------+---------+---------+------+
+// | 2byte |  1byte  | uvarint | Size |
+// +-------+---------+---------+------+
 func sendFrame(w io.Writer, data []byte) (err error) {
+	var prefix [header.PrefixSize]byte
+	binary.BigEndian.PutUint16(prefix[:], header.Magic)
+	prefix[2] = header.Version
+	if err = write(w, prefix[:]); err != nil {
+		return
+	}
+
 	var size [binary.MaxVarintLen64]byte
 
 	if len(data) == 0 {
@@ -31,11 +78,27 @@ func sendFrame(w io.Writer, data []byte) (err error) {
 	return
 }
 
-func recvFrame(r io.Reader) (data []byte, err error) {
+// recvFrame reads a frame, traffic which does not start with
+// the magic number is rejected before its size is trusted
+func recvFrame(r io.Reader, maxSize uint64) (data []byte, err error) {
+	var prefix [header.PrefixSize]byte
+	if err = read(r, prefix[:]); err != nil {
+		return nil, err
+	}
+	if binary.BigEndian.Uint16(prefix[:]) != header.Magic {
+		return nil, InvalidMagicError
+	}
+	if prefix[2] != header.Version {
+		return nil, UnsupportedVersionError
+	}
+
 	size, err := binary.ReadUvarint(r.(io.ByteReader))
 	if err != nil {
 		return nil, err
 	}
+	if size > maxSize {
+		return nil, &TooLargeError{What: "header", Size: size, Limit: maxSize}
+	}
 	if size != 0 {
 		data = make([]byte, size)
 		if err = read(r, data); err != nil {
@@ -48,7 +111,7 @@ func recvFrame(r io.Reader) (data []byte, err error) {
 func write(w io.Writer, data []byte) error {
 	for index := 0; index < len(data); {
 		n, err := w.Write(data[index:])
-		if _, ok := err.(net.Error); !ok {
+		if err != nil {
 			return err
 		}
 		index += n
@@ -56,15 +119,9 @@ func write(w io.Writer, data []byte) error {
 	return nil
 }
 
+// read reads exactly len(data) bytes, a connection which is closed or
+// broken in the middle of it is reported instead of being retried
 func read(r io.Reader, data []byte) error {
-	for index := 0; index < len(data); {
-		n, err := r.Read(data[index:])
-		if err != nil {
-			if _, ok := err.(net.Error); !ok {
-				return err
-			}
-		}
-		index += n
-	}
-	return nil
+	_, err := io.ReadFull(r, data)
+	return err
 }
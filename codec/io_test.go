@@ -0,0 +1,48 @@
+// Copyright 2022 <mzh.scnu@qq.com>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package codec
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestFrame .
+func TestFrame(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	assert.Equal(t, nil, sendFrame(buf, []byte("header")))
+	assert.Equal(t, []byte{0x74, 0x72, 0x1, 0x6, 'h', 'e', 'a', 'd', 'e', 'r'}, buf.Bytes())
+
+	data, err := recvFrame(bufio.NewReader(buf), DefaultMaxHeaderSize)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []byte("header"), data)
+}
+
+// TestRecvFrame .
+func TestRecvFrame(t *testing.T) {
+	cases := []struct {
+		name   string
+		data   []byte
+		expect error
+	}{
+		{"test-1", []byte("GET / HTTP/1.1\r\n\r\n"), InvalidMagicError},
+		{"test-2", []byte{0x74, 0x72, 0x2, 0x0}, UnsupportedVersionError},
+		{"test-3", []byte{}, io.EOF},
+		{"test-4", []byte{0x74}, io.ErrUnexpectedEOF},
+		{"test-5", []byte{0x74, 0x72, 0x1, 0x3, 'h'}, io.ErrUnexpectedEOF},
+		{"test-6", []byte{0x74, 0x72, 0x1, 0xff, 0xff, 0xff, 0xff, 0xf},
+			&TooLargeError{What: "header", Size: 1<<32 - 1, Limit: 4}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			_, err := recvFrame(bufio.NewReader(bytes.NewReader(c.data)), 4)
+			assert.Equal(t, c.expect, err)
+		})
+	}
+}
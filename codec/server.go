@@ -6,6 +6,7 @@ package codec
 
 import (
 	"bufio"
+	"crypto/sha256"
 	"hash/crc32"
 	"io"
 	"net/rpc"
@@ -16,9 +17,31 @@ import (
 	"github.com/zehuamama/tinyrpc/serializer"
 )
 
-type reqCtx struct {
-	requestID   uint64
-	compareType compressor.CompressType
+// ServerCodec implements the server side of the tinyrpc protocol. Unlike
+// rpc.ServerCodec it works with complete tinyrpc headers, so the caller
+// sees every field the client sent.
+type ServerCodec interface {
+	// ReadRequestHeader reads the next request header into h
+	ReadRequestHeader(h *header.RequestHeader) error
+	// ReadRequestBody reads the body described by h into param,
+	// the body is discarded when param is nil
+	ReadRequestBody(h *header.RequestHeader, param interface{}) error
+	// ReadRequestPayload reads the body described by h, it is checked and
+	// decompressed but not deserialized, so it can be decoded later
+	ReadRequestPayload(h *header.RequestHeader) ([]byte, error)
+	// WriteResponse fills in the body related fields of h and writes
+	// the response header and body to the io stream, h.CompressType
+	// must be set to the compress type of the request
+	WriteResponse(h *header.ResponseHeader, param interface{}) error
+	Close() error
+}
+
+// BodyDigester is implemented by server codecs which can tell the SHA-256
+// of a request body as it was sent, so a signature can cover the body
+type BodyDigester interface {
+	// BodyDigest returns the digest of the compressed body of the last
+	// request read, the body of a request without one is empty
+	BodyDigest() []byte
 }
 
 type serverCodec struct {
@@ -26,98 +49,96 @@ type serverCodec struct {
 	w io.Writer
 	c io.Closer
 
-	request    header.RequestHeader
 	serializer serializer.Serializer
-	mutex      sync.Mutex // protects seq, pending
-	seq        uint64
-	pending    map[uint64]*reqCtx
+	limits     Limits
+	body       []byte // the body of the last request as it was sent
 }
 
-// NewServerCodec Create a new server codec
-func NewServerCodec(conn io.ReadWriteCloser, serializer serializer.Serializer) rpc.ServerCodec {
+// NewServer Create a new tinyrpc server codec
+func NewServer(conn io.ReadWriteCloser, serializer serializer.Serializer, limits Limits) ServerCodec {
 	return &serverCodec{
 		r:          bufio.NewReader(conn),
 		w:          bufio.NewWriter(conn),
 		c:          conn,
 		serializer: serializer,
-		pending:    make(map[uint64]*reqCtx),
+		limits:     limits,
 	}
 }
 
 // ReadRequestHeader read the rpc request header from the io stream
-func (s *serverCodec) ReadRequestHeader(r *rpc.Request) error {
-	s.request.ResetHeader()
-	data, err := recvFrame(s.r)
+func (s *serverCodec) ReadRequestHeader(h *header.RequestHeader) error {
+	s.body = nil
+	data, err := recvFrame(s.r, s.limits.maxHeaderSize())
 	if err != nil {
 		return err
 	}
-	err = s.request.Unmarshal(data)
-	if err != nil {
-		return err
-	}
-	s.mutex.Lock()
-	s.seq++
-	s.pending[s.seq] = &reqCtx{s.request.ID, s.request.GetCompressType()}
-	r.ServiceMethod = s.request.Method
-	r.Seq = s.seq
-	s.mutex.Unlock()
-	return nil
+	return h.Unmarshal(data)
+}
+
+// BodyDigest returns the SHA-256 of the last request body as it was sent
+func (s *serverCodec) BodyDigest() []byte {
+	sum := sha256.Sum256(s.body)
+	return sum[:]
 }
 
 // ReadRequestBody read the rpc request body from the io stream
-func (s *serverCodec) ReadRequestBody(param interface{}) error {
+func (s *serverCodec) ReadRequestBody(h *header.RequestHeader, param interface{}) error {
+	if err := s.limits.checkMessageSize(h.RequestLen); err != nil {
+		return err
+	}
 	if param == nil {
-		if s.request.RequestLen != 0 {
-			if err := read(s.r, make([]byte, s.request.RequestLen)); err != nil {
+		if h.RequestLen != 0 {
+			body := make([]byte, h.RequestLen)
+			if err := read(s.r, body); err != nil {
 				return err
 			}
+			s.body = body
 		}
 		return nil
 	}
 
-	reqBody := make([]byte, s.request.RequestLen)
+	req, err := s.ReadRequestPayload(h)
+	if err != nil {
+		return err
+	}
+	return s.serializer.Unmarshal(req, param)
+}
+
+// ReadRequestPayload read the rpc request body from the io stream without deserializing it
+func (s *serverCodec) ReadRequestPayload(h *header.RequestHeader) ([]byte, error) {
+	if err := s.limits.checkMessageSize(h.RequestLen); err != nil {
+		return nil, err
+	}
+	reqBody := make([]byte, h.RequestLen)
 
 	err := read(s.r, reqBody)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	s.body = reqBody
 
-	if s.request.Checksum != 0 {
-		if crc32.ChecksumIEEE(reqBody) != s.request.Checksum {
-			return UnexpectedChecksumError
+	if h.Checksum != 0 {
+		if crc32.ChecksumIEEE(reqBody) != h.Checksum {
+			return nil, UnexpectedChecksumError
 		}
 	}
 
 	if _, ok := compressor.
-		Compressors[s.request.GetCompressType()]; !ok {
-		return NotFoundCompressorError
-	}
-
-	req, err := compressor.
-		Compressors[s.request.GetCompressType()].Unzip(reqBody)
-	if err != nil {
-		return err
+		Compressors[h.GetCompressType()]; !ok {
+		return nil, NotFoundCompressorError
 	}
 
-	return s.serializer.Unmarshal(req, param)
+	return compressor.
+		Compressors[h.GetCompressType()].Unzip(reqBody)
 }
 
 // WriteResponse Write the rpc response header and body to the io stream
-func (s *serverCodec) WriteResponse(r *rpc.Response, param interface{}) error {
-	s.mutex.Lock()
-	reqCtx, ok := s.pending[r.Seq]
-	if !ok {
-		s.mutex.Unlock()
-		return InvalidSequenceError
-	}
-	delete(s.pending, r.Seq)
-	s.mutex.Unlock()
-
-	if r.Error != "" {
+func (s *serverCodec) WriteResponse(h *header.ResponseHeader, param interface{}) error {
+	if h.Error != "" {
 		param = nil
 	}
 	if _, ok := compressor.
-		Compressors[reqCtx.compareType]; !ok {
+		Compressors[h.GetCompressType()]; !ok {
 		return NotFoundCompressorError
 	}
 
@@ -131,10 +152,80 @@ func (s *serverCodec) WriteResponse(r *rpc.Response, param interface{}) error {
 	}
 
 	compressedRespBody, err := compressor.
-		Compressors[reqCtx.compareType].Zip(respBody)
+		Compressors[h.GetCompressType()].Zip(respBody)
 	if err != nil {
 		return err
 	}
+	h.ResponseLen = uint32(len(compressedRespBody))
+	h.Checksum = crc32.ChecksumIEEE(compressedRespBody)
+
+	if err = sendFrame(s.w, h.Marshal()); err != nil {
+		return err
+	}
+
+	if err = write(s.w, compressedRespBody); err != nil {
+		return err
+	}
+	return s.w.(*bufio.Writer).Flush()
+}
+
+func (s *serverCodec) Close() error {
+	return s.c.Close()
+}
+
+type reqCtx struct {
+	requestID   uint64
+	compareType compressor.CompressType
+}
+
+// rpcServerCodec adapts ServerCodec to rpc.ServerCodec
+type rpcServerCodec struct {
+	codec   ServerCodec
+	request header.RequestHeader
+	mutex   sync.Mutex // protects seq, pending
+	seq     uint64
+	pending map[uint64]*reqCtx
+}
+
+// NewServerCodec Create a new server codec which can be used by net/rpc
+func NewServerCodec(conn io.ReadWriteCloser, serializer serializer.Serializer) rpc.ServerCodec {
+	return &rpcServerCodec{
+		codec:   NewServer(conn, serializer, Limits{}),
+		pending: make(map[uint64]*reqCtx),
+	}
+}
+
+// ReadRequestHeader read the rpc request header from the io stream
+func (s *rpcServerCodec) ReadRequestHeader(r *rpc.Request) error {
+	s.request.ResetHeader()
+	if err := s.codec.ReadRequestHeader(&s.request); err != nil {
+		return err
+	}
+	s.mutex.Lock()
+	s.seq++
+	s.pending[s.seq] = &reqCtx{s.request.ID, s.request.GetCompressType()}
+	r.ServiceMethod = s.request.Method
+	r.Seq = s.seq
+	s.mutex.Unlock()
+	return nil
+}
+
+// ReadRequestBody read the rpc request body from the io stream
+func (s *rpcServerCodec) ReadRequestBody(param interface{}) error {
+	return s.codec.ReadRequestBody(&s.request, param)
+}
+
+// WriteResponse Write the rpc response header and body to the io stream
+func (s *rpcServerCodec) WriteResponse(r *rpc.Response, param interface{}) error {
+	s.mutex.Lock()
+	reqCtx, ok := s.pending[r.Seq]
+	if !ok {
+		s.mutex.Unlock()
+		return InvalidSequenceError
+	}
+	delete(s.pending, r.Seq)
+	s.mutex.Unlock()
+
 	h := header.ResponsePool.Get().(*header.ResponseHeader)
 	defer func() {
 		h.ResetHeader()
@@ -142,21 +233,88 @@ func (s *serverCodec) WriteResponse(r *rpc.Response, param interface{}) error {
 	}()
 	h.ID = reqCtx.requestID
 	h.Error = r.Error
-	h.ResponseLen = uint32(len(compressedRespBody))
-	h.Checksum = crc32.ChecksumIEEE(compressedRespBody)
 	h.CompressType = reqCtx.compareType
+	return s.codec.WriteResponse(h, param)
+}
 
-	if err = sendFrame(s.w, h.Marshal()); err != nil {
-		return err
+func (s *rpcServerCodec) Close() error {
+	return s.codec.Close()
+}
+
+// serverCodecAdapter adapts rpc.ServerCodec to ServerCodec
+type serverCodecAdapter struct {
+	codec   rpc.ServerCodec
+	request rpc.Request
+	mutex   sync.Mutex // protects pending
+	pending map[uint64]string
+}
+
+// FromRPCServerCodec adapts a net/rpc server codec, like jsonrpc.NewServerCodec,
+// so that it can be served by tinyrpc.Server. The net/rpc wire format has no
+// room for metadata, status codes, streams, pings or one-way calls, these are not supported then.
+func FromRPCServerCodec(cc rpc.ServerCodec) ServerCodec {
+	return &serverCodecAdapter{
+		codec:   cc,
+		pending: make(map[uint64]string),
 	}
+}
 
-	if err = write(s.w, compressedRespBody); err != nil {
+// ReadRequestHeader read the rpc request header from the io stream
+func (s *serverCodecAdapter) ReadRequestHeader(h *header.RequestHeader) error {
+	s.request = rpc.Request{}
+	if err := s.codec.ReadRequestHeader(&s.request); err != nil {
 		return err
 	}
-	s.w.(*bufio.Writer).Flush()
+	s.mutex.Lock()
+	s.pending[s.request.Seq] = s.request.ServiceMethod
+	s.mutex.Unlock()
+	h.Method = s.request.ServiceMethod
+	h.ID = s.request.Seq
 	return nil
 }
 
-func (s *serverCodec) Close() error {
-	return s.c.Close()
+// ReadRequestBody read the rpc request body from the io stream
+func (s *serverCodecAdapter) ReadRequestBody(h *header.RequestHeader, param interface{}) error {
+	return s.codec.ReadRequestBody(param)
+}
+
+// ReadRequestPayload is not supported since the body is decoded by the net/rpc codec
+func (s *serverCodecAdapter) ReadRequestPayload(h *header.RequestHeader) ([]byte, error) {
+	if err := s.codec.ReadRequestBody(nil); err != nil {
+		return nil, err
+	}
+	return nil, StreamNotSupportedError
+}
+
+// WriteResponse Write the rpc response header and body to the io stream
+func (s *serverCodecAdapter) WriteResponse(h *header.ResponseHeader, param interface{}) error {
+	if h.Flags&header.FlagGoAway != 0 {
+		// net/rpc clients learn about the shutdown when the connection is closed
+		return nil
+	}
+	if h.Flags != 0 {
+		return StreamNotSupportedError
+	}
+	s.mutex.Lock()
+	serviceMethod, ok := s.pending[h.ID]
+	if !ok {
+		s.mutex.Unlock()
+		return InvalidSequenceError
+	}
+	delete(s.pending, h.ID)
+	s.mutex.Unlock()
+
+	if param == nil {
+		// some codecs like gob can not encode nil
+		param = struct{}{}
+	}
+	return s.codec.WriteResponse(&rpc.Response{
+		ServiceMethod: serviceMethod,
+		Seq:           h.ID,
+		Error:         h.Error,
+	}, param)
+}
+
+func (s *serverCodecAdapter) Close() error {
+	return s.codec.Close()
 }
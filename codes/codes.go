@@ -0,0 +1,78 @@
+// Copyright 2022 <mzh.scnu@qq.com>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package codes defines the status codes of rpc errors, see package status
+package codes
+
+import "strconv"
+
+// Code is the status code of an rpc error, the values match gRPC
+type Code uint32
+
+const (
+	// OK is returned on success
+	OK Code = iota
+	// Canceled indicates the call was canceled, typically by the caller
+	Canceled
+	// Unknown is the code of errors which carry no code
+	Unknown
+	// InvalidArgument indicates the client specified an invalid argument
+	InvalidArgument
+	// DeadlineExceeded means the call expired before it completed
+	DeadlineExceeded
+	// NotFound means some requested entity was not found
+	NotFound
+	// AlreadyExists means an entity the client attempted to create already exists
+	AlreadyExists
+	// PermissionDenied indicates the caller may not execute the call
+	PermissionDenied
+	// ResourceExhausted indicates some resource has been exhausted,
+	// e.g. a rate limit or the capacity of the server
+	ResourceExhausted
+	// FailedPrecondition indicates the system is not in a state required
+	// for the call's execution
+	FailedPrecondition
+	// Aborted indicates the call was aborted, e.g. because of a concurrency conflict
+	Aborted
+	// OutOfRange means the call was attempted past the valid range
+	OutOfRange
+	// Unimplemented indicates the method is not implemented or not registered
+	Unimplemented
+	// Internal means an invariant of the server has been broken
+	Internal
+	// Unavailable indicates the service is currently unavailable,
+	// the call is likely to succeed if it is retried later
+	Unavailable
+	// DataLoss indicates unrecoverable data loss or corruption
+	DataLoss
+	// Unauthenticated indicates the call lacks valid credentials
+	Unauthenticated
+)
+
+var codeNames = [...]string{
+	OK:                 "OK",
+	Canceled:           "Canceled",
+	Unknown:            "Unknown",
+	InvalidArgument:    "InvalidArgument",
+	DeadlineExceeded:   "DeadlineExceeded",
+	NotFound:           "NotFound",
+	AlreadyExists:      "AlreadyExists",
+	PermissionDenied:   "PermissionDenied",
+	ResourceExhausted:  "ResourceExhausted",
+	FailedPrecondition: "FailedPrecondition",
+	Aborted:            "Aborted",
+	OutOfRange:         "OutOfRange",
+	Unimplemented:      "Unimplemented",
+	Internal:           "Internal",
+	Unavailable:        "Unavailable",
+	DataLoss:           "DataLoss",
+	Unauthenticated:    "Unauthenticated",
+}
+
+func (c Code) String() string {
+	if int(c) < len(codeNames) {
+		return codeNames[c]
+	}
+	return "Code(" + strconv.FormatUint(uint64(c), 10) + ")"
+}
@@ -0,0 +1,271 @@
+// Copyright 2022 <mzh.scnu@qq.com>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tinyrpc
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zehuamama/tinyrpc/codec"
+	"github.com/zehuamama/tinyrpc/codes"
+	"github.com/zehuamama/tinyrpc/header"
+	"github.com/zehuamama/tinyrpc/status"
+)
+
+// AuthorizationKey is the metadata key which carries the credentials of a request
+const AuthorizationKey = "authorization"
+
+const (
+	bearerScheme = "Bearer "
+	hmacScheme   = "HMAC-SHA256 "
+)
+
+var (
+	errNoCredentials  = errors.New("missing credentials")
+	errBadCredentials = errors.New("invalid credentials")
+	errExpired        = errors.New("request signature expired")
+	errReplayed       = errors.New("request replayed")
+)
+
+// Credentials adds credentials to every request of a client
+type Credentials interface {
+	// Sign adds the credentials of the request h to h.Metadata, the body
+	// related fields of h like Checksum are filled in already and digest
+	// is the SHA-256 of the compressed body which follows h
+	Sign(h *header.RequestHeader, digest []byte) error
+}
+
+// Verifier checks the credentials of the requests of a server
+type Verifier interface {
+	// Verify checks the credentials of the request h before it is dispatched,
+	// digest is the SHA-256 of the compressed body of the request, or nil if
+	// the codec can not tell it. The returned context is passed to the
+	// handler, so it can carry the identity of the client. Errors without a
+	// status are sent as codes.Unauthenticated.
+	Verify(ctx context.Context, h *header.RequestHeader, digest []byte) (context.Context, error)
+}
+
+// WithCredentials makes the client add the credentials to every request,
+// it applies to clients created by NewClient and Dial
+func WithCredentials(c Credentials) Option {
+	return func(o *options) {
+		o.credentials = c
+	}
+}
+
+// WithVerifier makes the server check the credentials of every request
+// before it is dispatched. Later frames of a stream belong to a call
+// which has been verified and are not checked again.
+func WithVerifier(v Verifier) Option {
+	return func(o *options) {
+		o.verifier = v
+	}
+}
+
+// signFunc adapts c to the client codec, the metadata of the caller
+// is copied before the credentials are added to it
+func signFunc(c Credentials) codec.SignFunc {
+	if c == nil {
+		return nil
+	}
+	return func(h *header.RequestHeader, body []byte) error {
+		if h.Method == "" {
+			// frames of a call which has been signed
+			return nil
+		}
+		md := make(map[string]string, len(h.Metadata)+1)
+		for k, v := range h.Metadata {
+			md[k] = v
+		}
+		h.Metadata = md
+		digest := sha256.Sum256(body)
+		return c.Sign(h, digest[:])
+	}
+}
+
+// authenticate verifies the credentials of req read from c, the context
+// of req is replaced with the one of the verifier
+func (s *Server) authenticate(c *serverConn, req *request) error {
+	if s.verifier == nil {
+		return nil
+	}
+	var digest []byte
+	if d, ok := c.cc.(codec.BodyDigester); ok {
+		// the next request is not read before req is dispatched
+		digest = d.BodyDigest()
+	}
+	ctx, err := s.verifier.Verify(req.ctx, &req.h, digest)
+	if err != nil {
+		if _, ok := status.FromError(err); ok {
+			return err
+		}
+		return status.Error(codes.Unauthenticated, err.Error())
+	}
+	if ctx != nil {
+		req.ctx = ctx
+	}
+	return nil
+}
+
+type bearerToken string
+
+// BearerToken returns credentials which send token with every request
+func BearerToken(token string) Credentials {
+	return bearerToken(token)
+}
+
+func (t bearerToken) Sign(h *header.RequestHeader, digest []byte) error {
+	h.Metadata[AuthorizationKey] = bearerScheme + string(t)
+	return nil
+}
+
+type bearerVerifier func(ctx context.Context, token string) (context.Context, error)
+
+// BearerVerifier returns a verifier which accepts the requests whose bearer
+// token is accepted by check, check returns the context of the handler
+func BearerVerifier(check func(ctx context.Context, token string) (context.Context, error)) Verifier {
+	return bearerVerifier(check)
+}
+
+func (v bearerVerifier) Verify(ctx context.Context, h *header.RequestHeader, digest []byte) (context.Context, error) {
+	auth, ok := h.Metadata[AuthorizationKey]
+	if !ok {
+		return nil, errNoCredentials
+	}
+	if !strings.HasPrefix(auth, bearerScheme) {
+		return nil, errBadCredentials
+	}
+	return v(ctx, strings.TrimPrefix(auth, bearerScheme))
+}
+
+// hmacSignature signs the fields of h which describe the request, the
+// digest of its body and its metadata but the credentials themselves
+func hmacSignature(key []byte, h *header.RequestHeader, digest []byte, timestamp int64, nonce string) []byte {
+	mac := hmac.New(sha256.New, key)
+	fmt.Fprintf(mac, "%q\n%d\n%d\n%d\n%d\n%d\n%x\n",
+		h.Method, h.ID, h.Timeout, h.Flags, h.CompressType, h.Window, digest)
+	keys := make([]string, 0, len(h.Metadata))
+	for k := range h.Metadata {
+		if k != AuthorizationKey {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(mac, "%q:%q\n", k, h.Metadata[k])
+	}
+	fmt.Fprintf(mac, "%d\n%s", timestamp, nonce)
+	return mac.Sum(nil)
+}
+
+type hmacCredentials []byte
+
+// HMACCredentials returns credentials which sign every request with key, the
+// signature covers the method, the request ID, the timeout, the flags, the
+// compress type, the stream window, the SHA-256 of the body, the metadata,
+// the time and a random nonce, so a request can be neither changed nor replayed
+func HMACCredentials(key []byte) Credentials {
+	return hmacCredentials(key)
+}
+
+func (key hmacCredentials) Sign(h *header.RequestHeader, digest []byte) error {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return err
+	}
+	nonce := hex.EncodeToString(b[:])
+	timestamp := time.Now().UnixNano()
+	sig := base64.RawURLEncoding.EncodeToString(hmacSignature(key, h, digest, timestamp, nonce))
+	h.Metadata[AuthorizationKey] = fmt.Sprintf("%s%d:%s:%s", hmacScheme, timestamp, nonce, sig)
+	return nil
+}
+
+// hmacVerifier checks HMAC signatures and remembers the nonces
+// it has seen until their requests expire
+type hmacVerifier struct {
+	key    []byte
+	maxAge time.Duration
+
+	mutex   sync.Mutex           // protects following
+	nonces  map[string]time.Time // nonce -> expiry
+	sweepAt int                  // the number of nonces which triggers the next sweep
+}
+
+// HMACVerifier returns a verifier which accepts the requests signed by
+// HMACCredentials with key. Requests signed more than maxAge ago or ahead
+// of the clock of the server are rejected, as well as nonces seen before.
+func HMACVerifier(key []byte, maxAge time.Duration) Verifier {
+	return &hmacVerifier{
+		key:     key,
+		maxAge:  maxAge,
+		nonces:  make(map[string]time.Time),
+		sweepAt: minSweep,
+	}
+}
+
+func (v *hmacVerifier) Verify(ctx context.Context, h *header.RequestHeader, digest []byte) (context.Context, error) {
+	auth, ok := h.Metadata[AuthorizationKey]
+	if !ok {
+		return nil, errNoCredentials
+	}
+	if !strings.HasPrefix(auth, hmacScheme) {
+		return nil, errBadCredentials
+	}
+	parts := strings.Split(strings.TrimPrefix(auth, hmacScheme), ":")
+	if len(parts) != 3 {
+		return nil, errBadCredentials
+	}
+	timestamp, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return nil, errBadCredentials
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil || !hmac.Equal(sig, hmacSignature(v.key, h, digest, timestamp, parts[1])) {
+		return nil, errBadCredentials
+	}
+
+	now := time.Now()
+	signed := time.Unix(0, timestamp)
+	if age := now.Sub(signed); age > v.maxAge || age < -v.maxAge {
+		return nil, errExpired
+	}
+	if !v.remember(parts[1], signed.Add(v.maxAge), now) {
+		return nil, errReplayed
+	}
+	return ctx, nil
+}
+
+// remember records a nonce until it expires, it reports false if the nonce has been seen
+func (v *hmacVerifier) remember(nonce string, expiry, now time.Time) bool {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	if _, seen := v.nonces[nonce]; seen {
+		return false
+	}
+	if len(v.nonces) >= v.sweepAt {
+		for n, e := range v.nonces {
+			if now.After(e) {
+				delete(v.nonces, n)
+			}
+		}
+		v.sweepAt = 2 * len(v.nonces)
+		if v.sweepAt < minSweep {
+			v.sweepAt = minSweep
+		}
+	}
+	v.nonces[nonce] = expiry
+	return true
+}
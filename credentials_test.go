@@ -0,0 +1,162 @@
+package tinyrpc
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zehuamama/tinyrpc/codes"
+	"github.com/zehuamama/tinyrpc/compressor"
+	"github.com/zehuamama/tinyrpc/header"
+	"github.com/zehuamama/tinyrpc/metadata"
+	"github.com/zehuamama/tinyrpc/status"
+	pb "github.com/zehuamama/tinyrpc/test.data/message"
+)
+
+type userKey struct{}
+
+type WhoamiService struct {
+	users chan interface{}
+}
+
+func (s *WhoamiService) Get(ctx context.Context, args *pb.ArithRequest, reply *pb.ArithResponse) error {
+	s.users <- ctx.Value(userKey{})
+	return nil
+}
+
+// tamperedCredentials signs requests with Credentials and changes
+// them afterwards, like a man in the middle would
+type tamperedCredentials struct {
+	Credentials
+	tamper func(h *header.RequestHeader)
+}
+
+func (c tamperedCredentials) Sign(h *header.RequestHeader, digest []byte) error {
+	if err := c.Credentials.Sign(h, digest); err != nil {
+		return err
+	}
+	c.tamper(h)
+	return nil
+}
+
+// TestBearerToken .
+func TestBearerToken(t *testing.T) {
+	server := NewServer(WithVerifier(BearerVerifier(func(ctx context.Context, token string) (context.Context, error) {
+		if token != "secret" {
+			return nil, errors.New("unknown token")
+		}
+		return context.WithValue(ctx, userKey{}, "alice"), nil
+	})))
+	whoami := &WhoamiService{users: make(chan interface{}, 1)}
+	err := server.Register(whoami)
+	assert.Equal(t, nil, err)
+
+	client := serve(t, server)
+	err = client.Call("WhoamiService.Get", &pb.ArithRequest{}, &pb.ArithResponse{})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	client = serve(t, server, WithCredentials(BearerToken("wrong")))
+	err = client.Call("WhoamiService.Get", &pb.ArithRequest{}, &pb.ArithResponse{})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	assert.Equal(t, "unknown token", status.Convert(err).Message())
+
+	client = serve(t, server, WithCredentials(BearerToken("secret")))
+	md := metadata.Pairs("tenant", "acme")
+	ctx := metadata.NewOutgoingContext(context.Background(), md)
+	err = client.CallContext(ctx, "WhoamiService.Get", &pb.ArithRequest{}, &pb.ArithResponse{})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "alice", <-whoami.users)
+	// the metadata of the caller is left alone
+	assert.Equal(t, metadata.Pairs("tenant", "acme"), md)
+}
+
+// TestHMACCredentials .
+func TestHMACCredentials(t *testing.T) {
+	key := []byte("key")
+	server := NewServer(WithVerifier(HMACVerifier(key, time.Minute)))
+	err := server.Register(new(pb.ArithService))
+	assert.Equal(t, nil, err)
+
+	client := serve(t, server, WithCredentials(HMACCredentials(key)))
+	for i := 0; i < 3; i++ {
+		resp := &pb.ArithResponse{}
+		err = client.Call("ArithService.Add", &pb.ArithRequest{A: 20, B: 5}, resp)
+		assert.Equal(t, nil, err)
+		assert.Equal(t, float64(25), resp.C)
+	}
+	// the metadata and the timeout are signed as they are sent
+	ctx := metadata.NewOutgoingContext(context.Background(), metadata.Pairs("tenant", "acme", "b", ""))
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+	err = client.CallContext(ctx, "ArithService.Add", &pb.ArithRequest{A: 20, B: 5}, &pb.ArithResponse{})
+	assert.Equal(t, nil, err)
+
+	client = serve(t, server, WithCredentials(HMACCredentials([]byte("other"))))
+	err = client.Call("ArithService.Add", &pb.ArithRequest{A: 20, B: 5}, &pb.ArithResponse{})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	// the flags are signed as well
+	client = serve(t, server, WithCredentials(tamperedCredentials{HMACCredentials(key), func(h *header.RequestHeader) {
+		h.Flags ^= header.FlagEndStream
+	}}))
+	err = client.Call("ArithService.Add", &pb.ArithRequest{A: 20, B: 5}, &pb.ArithResponse{})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+}
+
+// TestHMACVerifier .
+func TestHMACVerifier(t *testing.T) {
+	key := []byte("key")
+	digest := sha256.Sum256([]byte("body"))
+	sign := func() *header.RequestHeader {
+		h := &header.RequestHeader{Method: "ArithService.Add", ID: 7, Timeout: time.Second,
+			Metadata: map[string]string{"tenant": "acme"}}
+		assert.Equal(t, nil, HMACCredentials(key).Sign(h, digest[:]))
+		return h
+	}
+	v := HMACVerifier(key, time.Minute)
+	ctx := context.Background()
+
+	h := sign()
+	_, err := v.Verify(ctx, h, digest[:])
+	assert.Equal(t, nil, err)
+	_, err = v.Verify(ctx, h, digest[:])
+	assert.Equal(t, errReplayed, err)
+
+	// the signature covers the method, the request ID, the timeout,
+	// the flags, the compress type, the window, the body and the metadata
+	h = sign()
+	other := sha256.Sum256([]byte("other"))
+	_, err = v.Verify(ctx, h, other[:])
+	assert.Equal(t, errBadCredentials, err)
+	_, err = v.Verify(ctx, h, nil)
+	assert.Equal(t, errBadCredentials, err)
+	for _, tamper := range []func(h *header.RequestHeader){
+		func(h *header.RequestHeader) { h.ID++ },
+		func(h *header.RequestHeader) { h.Method = "ArithService.Div" },
+		func(h *header.RequestHeader) { h.Timeout = time.Hour },
+		func(h *header.RequestHeader) { h.Flags ^= header.FlagOneWay },
+		func(h *header.RequestHeader) { h.CompressType = compressor.Gzip },
+		func(h *header.RequestHeader) { h.Window = 100 },
+		func(h *header.RequestHeader) { h.Metadata["tenant"] = "other" },
+		func(h *header.RequestHeader) { h.Metadata["role"] = "admin" },
+		func(h *header.RequestHeader) { delete(h.Metadata, "tenant") },
+	} {
+		h = sign()
+		tamper(h)
+		_, err = v.Verify(ctx, h, digest[:])
+		assert.Equal(t, errBadCredentials, err)
+	}
+
+	_, err = v.Verify(ctx, &header.RequestHeader{Method: "ArithService.Add"}, digest[:])
+	assert.Equal(t, errNoCredentials, err)
+	_, err = v.Verify(ctx, &header.RequestHeader{Metadata: map[string]string{AuthorizationKey: hmacScheme + "1:2"}}, digest[:])
+	assert.Equal(t, errBadCredentials, err)
+
+	h = sign()
+	time.Sleep(time.Millisecond)
+	_, err = HMACVerifier(key, time.Microsecond).Verify(ctx, h, digest[:])
+	assert.Equal(t, errExpired, err)
+}
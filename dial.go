@@ -0,0 +1,597 @@
+// Copyright 2022 <mzh.scnu@qq.com>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tinyrpc
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"log"
+	"net"
+	"net/rpc"
+	"sync"
+	"time"
+
+	"github.com/zehuamama/tinyrpc/resolver"
+)
+
+// ErrUnavailable is returned by a ClientConn when none of its
+// connections is able to send a call, e.g. while they are redialed
+var ErrUnavailable = errors.New("tinyrpc: no connection available")
+
+// WithPoolSize sets the number of connections Dial opens to every endpoint,
+// calls are spread over them by the balancer. The default is 1.
+func WithPoolSize(n int) Option {
+	return func(o *options) {
+		o.poolSize = n
+	}
+}
+
+// WithReconnectBackoff sets the delays between the attempts to redial a
+// broken connection, the delay starts at base and doubles up to max.
+// The default is 100ms up to 10s, a non-positive base or max keeps its
+// default and max is raised to base if it is lower.
+func WithReconnectBackoff(base, max time.Duration) Option {
+	if base <= 0 {
+		base = defaultBackoffBase
+	}
+	if max <= 0 {
+		max = defaultBackoffMax
+	}
+	if max < base {
+		max = base
+	}
+	return func(o *options) {
+		o.backoff = backoff{base: base, max: max}
+	}
+}
+
+// ClientConn is a client which owns its connections, it dials them,
+// spreads calls over them and redials a connection when it breaks
+type ClientConn struct {
+	network    string
+	opts       []Option
+	poolSize   int
+	backoff    backoff
+	retrier    *retrier    // nil if calls are not retried
+	tls        *tls.Config // nil if the connections do not use TLS
+	balancer   Balancer
+	breakers   *breakers       // per endpoint, nil without a circuit breaker
+	hedging    *HedgingPolicy  // nil if calls are not hedged
+	idempotent map[string]bool // the methods which may be hedged
+
+	healthService  string
+	healthInterval time.Duration // the endpoints are not checked if it is 0
+
+	mutex      sync.Mutex          // protects following
+	conns      []*subConn          // poolSize connections per endpoint
+	ejections  map[string]ejection // endpoints whose calls failed lately
+	notServing map[string]bool     // endpoints which reported not serving
+	closed     bool
+
+	done chan struct{} // closed by Close
+}
+
+// subConn is one connection to an endpoint
+type subConn struct {
+	addr    string
+	client  *Client       // nil while it is dialed, protected by the mutex of the ClientConn
+	removed chan struct{} // closed when the endpoint is gone
+}
+
+// ejection tracks the transport errors of an endpoint
+type ejection struct {
+	failures     int       // in a row
+	ejectedUntil time.Time // the endpoint is not picked before
+}
+
+// endpoint is a connection offered to the Balancer
+type endpoint struct {
+	addr   string
+	client *Client
+}
+
+func (e *endpoint) Addr() string {
+	return e.addr
+}
+
+func (e *endpoint) Pending() int {
+	return e.client.outstanding()
+}
+
+// Dial connects to the address on the named network, see net.Dial, and returns
+// a client which keeps the connections alive. opts configure both the pool and
+// the client of every connection. Dial fails if any connection can't be opened.
+func Dial(network, addr string, opts ...Option) (*ClientConn, error) {
+	cc := newClientConn(network, opts)
+	conns := cc.update([]string{addr})
+	for _, sc := range conns {
+		client, err := cc.connect(sc.addr)
+		if err != nil {
+			cc.Close()
+			return nil, err
+		}
+		sc.client = client
+	}
+	for _, sc := range conns {
+		go cc.redial(sc, sc.client)
+	}
+	if cc.healthInterval > 0 {
+		go cc.watchHealth()
+	}
+	return cc, nil
+}
+
+// DialResolver connects to the endpoints r resolves target to, see Resolver,
+// and keeps the connections in sync with them: endpoints which show up are
+// dialed, connections to endpoints which are gone are closed once their
+// calls are done. DialResolver fails if the target can't be resolved, an
+// endpoint which can't be reached is redialed in the background.
+func DialResolver(network, target string, r resolver.Resolver, opts ...Option) (*ClientConn, error) {
+	cc := newClientConn(network, opts)
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-cc.done
+		cancel()
+	}()
+	updates, err := r.Watch(ctx, target)
+	if err != nil {
+		cc.Close()
+		return nil, err
+	}
+	var wg sync.WaitGroup
+	for _, sc := range cc.update(<-updates) {
+		wg.Add(1)
+		go func(sc *subConn) {
+			client, err := cc.connect(sc.addr)
+			if err != nil {
+				client = nil
+			} else if !cc.setClient(sc, client) {
+				client.Close()
+				wg.Done()
+				return
+			}
+			wg.Done()
+			cc.redial(sc, client)
+		}(sc)
+	}
+	wg.Wait()
+	go cc.watch(updates)
+	if cc.healthInterval > 0 {
+		go cc.watchHealth()
+	}
+	return cc, nil
+}
+
+func newClientConn(network string, opts []Option) *ClientConn {
+	options := options{
+		poolSize: 1,
+		backoff:  backoff{base: defaultBackoffBase, max: defaultBackoffMax},
+	}
+	for _, option := range opts {
+		option(&options)
+	}
+	if options.poolSize < 1 {
+		options.poolSize = 1
+	}
+	if options.balancer == nil {
+		options.balancer = RoundRobin()
+	}
+	// the circuit breakers of a ClientConn are per endpoint instead of per method
+	opts = append(opts[:len(opts):len(opts)], withoutCircuitBreaker)
+	return &ClientConn{
+		network:    network,
+		opts:       opts,
+		poolSize:   options.poolSize,
+		backoff:    options.backoff,
+		retrier:    newRetrier(options.retryPolicy, options.idempotent),
+		tls:        options.tlsConfig,
+		balancer:   options.balancer,
+		breakers:   newBreakers(options.circuitBreaker),
+		hedging:    options.hedging,
+		idempotent: options.idempotent,
+		ejections:  make(map[string]ejection),
+		notServing: make(map[string]bool),
+
+		healthService:  options.healthService,
+		healthInterval: options.healthInterval,
+		done:           make(chan struct{}),
+	}
+}
+
+func withoutCircuitBreaker(o *options) {
+	o.circuitBreaker = nil
+}
+
+// watch applies the endpoint updates until the resolver stops sending them
+func (cc *ClientConn) watch(updates <-chan []string) {
+	for addrs := range updates {
+		for _, sc := range cc.update(addrs) {
+			go cc.redial(sc, nil)
+		}
+	}
+}
+
+// update replaces the endpoints with addrs, it retires the connections to
+// removed endpoints and returns the new connections, which are not dialed yet
+func (cc *ClientConn) update(addrs []string) []*subConn {
+	want := make(map[string]bool, len(addrs))
+	for _, addr := range addrs {
+		want[addr] = true
+	}
+
+	cc.mutex.Lock()
+	if cc.closed {
+		cc.mutex.Unlock()
+		return nil
+	}
+	conns := make([]*subConn, 0, len(addrs)*cc.poolSize)
+	var removed, added []*subConn
+	have := make(map[string]bool, len(cc.conns))
+	for _, sc := range cc.conns {
+		if want[sc.addr] {
+			conns = append(conns, sc)
+			have[sc.addr] = true
+		} else {
+			removed = append(removed, sc)
+			delete(cc.ejections, sc.addr)
+			delete(cc.notServing, sc.addr)
+			cc.breakers.remove(sc.addr)
+		}
+	}
+	for _, addr := range addrs {
+		if have[addr] {
+			continue
+		}
+		have[addr] = true
+		for i := 0; i < cc.poolSize; i++ {
+			sc := &subConn{addr: addr, removed: make(chan struct{})}
+			conns = append(conns, sc)
+			added = append(added, sc)
+		}
+	}
+	cc.conns = conns
+	for _, sc := range removed {
+		close(sc.removed)
+		if sc.client != nil {
+			go sc.client.retire()
+			sc.client = nil
+		}
+	}
+	cc.mutex.Unlock()
+	return added
+}
+
+// Call synchronously calls the rpc function
+func (cc *ClientConn) Call(serviceMethod string, args interface{}, reply interface{}) error {
+	return cc.CallContext(context.Background(), serviceMethod, args, reply)
+}
+
+// CallContext synchronously calls the rpc function over one of the connections,
+// see Client.CallContext. A failed call is retried on the next connection
+// according to the retry policy.
+func (cc *ClientConn) CallContext(ctx context.Context, serviceMethod string, args interface{}, reply interface{}) error {
+	if cc.hedged(serviceMethod) {
+		return cc.hedge(ctx, serviceMethod, args, reply)
+	}
+	for attempt := 0; ; attempt++ {
+		err := cc.call(ctx, serviceMethod, args, reply)
+		if err == nil || cc.retrier == nil || cc.isClosed() ||
+			!cc.retrier.retry(serviceMethod, attempt, err) {
+			return err
+		}
+		if e := cc.retrier.wait(ctx, attempt); e != nil {
+			return err
+		}
+	}
+}
+
+func (cc *ClientConn) call(ctx context.Context, serviceMethod string, args interface{}, reply interface{}) error {
+	e, err := cc.pick(ctx, serviceMethod)
+	if err != nil {
+		return err
+	}
+	return cc.callEndpoint(ctx, e, serviceMethod, args, reply)
+}
+
+// callEndpoint calls the rpc function over the connection e, through the
+// circuit breaker of its endpoint
+func (cc *ClientConn) callEndpoint(ctx context.Context, e *endpoint, serviceMethod string, args interface{}, reply interface{}) error {
+	b := cc.breakers.get(e.addr)
+	var generation uint64
+	var err error
+	if b != nil {
+		if generation, err = b.allow(); err != nil {
+			return err
+		}
+	}
+	err = e.client.CallContext(ctx, serviceMethod, args, reply)
+	if b != nil {
+		b.done(generation, err)
+	}
+	cc.report(e.addr, err)
+	return err
+}
+
+// Notify calls the rpc function over one of the connections without
+// waiting for it, see Client.Notify. Notifications are not retried.
+func (cc *ClientConn) Notify(serviceMethod string, args interface{}) error {
+	return cc.NotifyContext(context.Background(), serviceMethod, args)
+}
+
+// NotifyContext is Notify with the metadata and the deadline of ctx sent along
+func (cc *ClientConn) NotifyContext(ctx context.Context, serviceMethod string, args interface{}) error {
+	e, err := cc.pick(ctx, serviceMethod)
+	if err != nil {
+		return err
+	}
+	return e.client.NotifyContext(ctx, serviceMethod, args)
+}
+
+// NewStream starts a server-streaming call over one of the connections,
+// see Client.NewStream. Streams are not retried.
+func (cc *ClientConn) NewStream(ctx context.Context, serviceMethod string, args interface{}) (*ClientStream, error) {
+	e, err := cc.pick(ctx, serviceMethod)
+	if err != nil {
+		return nil, err
+	}
+	return e.client.NewStream(ctx, serviceMethod, args)
+}
+
+// NewBidiStream starts a client-streaming or bidirectional call over one of
+// the connections, see Client.NewBidiStream. Streams are not retried.
+func (cc *ClientConn) NewBidiStream(ctx context.Context, serviceMethod string) (*ClientStream, error) {
+	e, err := cc.pick(ctx, serviceMethod)
+	if err != nil {
+		return nil, err
+	}
+	return e.client.NewBidiStream(ctx, serviceMethod)
+}
+
+// AsyncCall asynchronously calls the rpc function and returns a channel of *rpc.Call
+func (cc *ClientConn) AsyncCall(serviceMethod string, args interface{}, reply interface{}) chan *rpc.Call {
+	return cc.Go(serviceMethod, args, reply, nil).Done
+}
+
+// Go invokes the function asynchronously over one of the connections, see Client.Go.
+// When calls are retried, hedged or watched by circuit breakers, they run on a new goroutine.
+func (cc *ClientConn) Go(serviceMethod string, args interface{}, reply interface{}, done chan *rpc.Call) *rpc.Call {
+	var e *endpoint
+	var err error
+	if cc.retrier == nil && cc.breakers == nil && !cc.hedged(serviceMethod) {
+		if e, err = cc.pick(context.Background(), serviceMethod); err == nil {
+			return e.client.Go(serviceMethod, args, reply, done)
+		}
+	}
+	if done == nil {
+		done = make(chan *rpc.Call, 10) // buffered.
+	} else if cap(done) == 0 {
+		log.Panic("rpc: done channel is unbuffered")
+	}
+	call := &rpc.Call{
+		ServiceMethod: serviceMethod,
+		Args:          args,
+		Reply:         reply,
+		Done:          done,
+	}
+	if err != nil {
+		call.Error = err
+		callDone(call)
+		return call
+	}
+	go func() {
+		call.Error = cc.CallContext(context.Background(), serviceMethod, args, reply)
+		callDone(call)
+	}()
+	return call
+}
+
+// Close closes every connection and stops redialing them
+func (cc *ClientConn) Close() error {
+	cc.mutex.Lock()
+	if cc.closed {
+		cc.mutex.Unlock()
+		return rpc.ErrShutdown
+	}
+	cc.closed = true
+	conns := cc.conns
+	cc.conns = nil
+	cc.mutex.Unlock()
+
+	close(cc.done)
+	for _, sc := range conns {
+		if sc.client != nil {
+			sc.client.Close()
+		}
+	}
+	return nil
+}
+
+func (cc *ClientConn) isClosed() bool {
+	cc.mutex.Lock()
+	defer cc.mutex.Unlock()
+	return cc.closed
+}
+
+// pick returns the connection the balancer picks among those able to send a
+// call. The endpoints whose circuit is open are left out, so are those which
+// failed lately unless none is left.
+func (cc *ClientConn) pick(ctx context.Context, serviceMethod string) (*endpoint, error) {
+	return cc.pickExcept(ctx, serviceMethod, nil)
+}
+
+// pickExcept is pick preferring the endpoints which are not in except
+func (cc *ClientConn) pickExcept(ctx context.Context, serviceMethod string, except map[string]bool) (*endpoint, error) {
+	cc.mutex.Lock()
+	if cc.closed {
+		cc.mutex.Unlock()
+		return nil, rpc.ErrShutdown
+	}
+	now := time.Now()
+	var ready, healthy []Endpoint
+	open := false
+	for _, sc := range cc.conns {
+		if sc.client == nil || !sc.client.available() {
+			continue
+		}
+		if cc.notServing[sc.addr] {
+			continue
+		}
+		if b := cc.breakers.get(sc.addr); b != nil && !b.ready() {
+			open = true
+			continue
+		}
+		e := &endpoint{addr: sc.addr, client: sc.client}
+		ready = append(ready, e)
+		if !now.Before(cc.ejections[sc.addr].ejectedUntil) {
+			healthy = append(healthy, e)
+		}
+	}
+	cc.mutex.Unlock()
+
+	if len(ready) == 0 {
+		if open {
+			return nil, ErrCircuitOpen
+		}
+		return nil, ErrUnavailable
+	}
+	if len(healthy) == 0 {
+		healthy = ready
+	}
+	if len(except) > 0 {
+		var others []Endpoint
+		for _, e := range healthy {
+			if !except[e.Addr()] {
+				others = append(others, e)
+			}
+		}
+		if len(others) > 0 {
+			healthy = others
+		}
+	}
+	picked, err := cc.balancer.Pick(healthy, PickInfo{Ctx: ctx, ServiceMethod: serviceMethod})
+	if err != nil {
+		return nil, err
+	}
+	e, ok := picked.(*endpoint)
+	if !ok {
+		return nil, errUnknownEndpoint
+	}
+	return e, nil
+}
+
+// report records the outcome of a call to addr, an endpoint is
+// ejected for a while after a transport error
+func (cc *ClientConn) report(addr string, err error) {
+	cc.mutex.Lock()
+	defer cc.mutex.Unlock()
+	if errors.Is(err, context.Canceled) {
+		// the caller gave up, the call tells nothing about the endpoint
+		return
+	}
+	ej, ok := cc.ejections[addr]
+	if !IsTransportError(err) {
+		if ok {
+			delete(cc.ejections, addr)
+		}
+		return
+	}
+	if cc.closed || !cc.has(addr) {
+		return
+	}
+	ej.ejectedUntil = time.Now().Add(cc.backoff.delay(ej.failures))
+	ej.failures++
+	cc.ejections[addr] = ej
+}
+
+// has reports whether addr is one of the endpoints
+func (cc *ClientConn) has(addr string) bool {
+	for _, sc := range cc.conns {
+		if sc.addr == addr {
+			return true
+		}
+	}
+	return false
+}
+
+func (cc *ClientConn) connect(addr string) (*Client, error) {
+	var conn net.Conn
+	var err error
+	if cc.tls != nil {
+		conn, err = tls.Dial(cc.network, addr, cc.tls)
+	} else {
+		conn, err = net.Dial(cc.network, addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return NewClient(conn, cc.opts...), nil
+}
+
+// setClient makes client the connection of sc, it reports false
+// if the ClientConn is closed or the endpoint is gone
+func (cc *ClientConn) setClient(sc *subConn, client *Client) bool {
+	cc.mutex.Lock()
+	defer cc.mutex.Unlock()
+	select {
+	case <-sc.removed:
+		return false
+	default:
+	}
+	if cc.closed {
+		return false
+	}
+	sc.client = client
+	return true
+}
+
+// redial replaces the client of sc whenever its connection is gone, until
+// the ClientConn is closed or the endpoint is removed. A nil client is
+// dialed first.
+func (cc *ClientConn) redial(sc *subConn, client *Client) {
+	for {
+		if client == nil {
+			if client = cc.reconnect(sc); client == nil {
+				return
+			}
+			if !cc.setClient(sc, client) {
+				client.Close()
+				return
+			}
+		}
+		select {
+		case <-client.done:
+		case <-sc.removed:
+			return
+		case <-cc.done:
+			return
+		}
+		if !cc.setClient(sc, nil) {
+			return
+		}
+		client = nil
+	}
+}
+
+// reconnect dials until it succeeds, waiting longer after every failure, it
+// returns nil if the ClientConn is closed or the endpoint is removed meanwhile
+func (cc *ClientConn) reconnect(sc *subConn) *Client {
+	for attempt := 0; ; attempt++ {
+		client, err := cc.connect(sc.addr)
+		if err == nil {
+			return client
+		}
+		timer := time.NewTimer(cc.backoff.delay(attempt))
+		select {
+		case <-timer.C:
+		case <-sc.removed:
+			timer.Stop()
+			return nil
+		case <-cc.done:
+			timer.Stop()
+			return nil
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package tinyrpc
+
+import (
+	"fmt"
+	"net"
+	"net/rpc"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zehuamama/tinyrpc/resolver"
+	pb "github.com/zehuamama/tinyrpc/test.data/message"
+)
+
+func listenArith(t *testing.T, addr string) (*Server, string) {
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := NewServer()
+	err = server.Register(new(pb.ArithService))
+	assert.Equal(t, nil, err)
+	go server.Serve(lis)
+	return server, lis.Addr().String()
+}
+
+// TestDial_Pool .
+func TestDial_Pool(t *testing.T) {
+	server, addr := listenArith(t, "127.0.0.1:0")
+	defer server.Close()
+
+	cc, err := Dial("tcp", addr, WithPoolSize(3))
+	assert.Equal(t, nil, err)
+	defer cc.Close()
+
+	for i := 0; i < 6; i++ {
+		resp := &pb.ArithResponse{}
+		err = cc.Call("ArithService.Add", &pb.ArithRequest{A: 20, B: 5}, resp)
+		assert.Equal(t, nil, err)
+		assert.Equal(t, float64(25), resp.C)
+	}
+	server.mu.Lock()
+	conns := len(server.conns)
+	server.mu.Unlock()
+	assert.Equal(t, 3, conns)
+
+	_, err = Dial("tcp", "127.0.0.1:1")
+	assert.NotEqual(t, nil, err)
+}
+
+// TestDial_Reconnect .
+func TestDial_Reconnect(t *testing.T) {
+	server, addr := listenArith(t, "127.0.0.1:0")
+
+	cc, err := Dial("tcp", addr, WithReconnectBackoff(10*time.Millisecond, 50*time.Millisecond))
+	assert.Equal(t, nil, err)
+	defer cc.Close()
+
+	resp := &pb.ArithResponse{}
+	err = cc.Call("ArithService.Add", &pb.ArithRequest{A: 20, B: 5}, resp)
+	assert.Equal(t, nil, err)
+
+	// the connection is lost
+	server.Close()
+	assert.Eventually(t, func() bool {
+		return cc.Call("ArithService.Add", &pb.ArithRequest{A: 20, B: 5}, resp) == ErrUnavailable
+	}, time.Second, 10*time.Millisecond)
+
+	// and redialed once the server is back
+	server, _ = listenArith(t, addr)
+	defer server.Close()
+	assert.Eventually(t, func() bool {
+		return cc.Call("ArithService.Add", &pb.ArithRequest{A: 20, B: 5}, resp) == nil
+	}, 2*time.Second, 10*time.Millisecond)
+	assert.Equal(t, float64(25), resp.C)
+
+	assert.Equal(t, nil, cc.Close())
+	assert.Equal(t, rpc.ErrShutdown, cc.Call("ArithService.Add", &pb.ArithRequest{A: 20, B: 5}, resp))
+}
+
+// TestWithReconnectBackoff .
+func TestWithReconnectBackoff(t *testing.T) {
+	var o options
+	// zero delays would make reconnect spin
+	WithReconnectBackoff(0, 0)(&o)
+	assert.Equal(t, backoff{base: defaultBackoffBase, max: defaultBackoffMax}, o.backoff)
+	assert.Less(t, int64(0), int64(o.backoff.delay(0)))
+
+	WithReconnectBackoff(time.Minute, time.Second)(&o)
+	assert.Equal(t, backoff{base: time.Minute, max: time.Minute}, o.backoff)
+	WithReconnectBackoff(-time.Second, time.Millisecond)(&o)
+	assert.Equal(t, backoff{base: defaultBackoffBase, max: defaultBackoffBase}, o.backoff)
+}
+
+// connCount returns the number of connections the server is serving
+func connCount(server *Server) int {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	return len(server.conns)
+}
+
+// TestDialResolver .
+func TestDialResolver(t *testing.T) {
+	server1, addr1 := listenArith(t, "127.0.0.1:0")
+	defer server1.Close()
+	server2, addr2 := listenArith(t, "127.0.0.1:0")
+	defer server2.Close()
+
+	path := filepath.Join(t.TempDir(), "hosts.json")
+	writeHosts := func(addrs string) {
+		err := os.WriteFile(path, []byte(fmt.Sprintf(`{"ArithService": [%s]}`, addrs)), 0600)
+		assert.Equal(t, nil, err)
+	}
+	writeHosts(fmt.Sprintf("%q", addr1))
+
+	cc, err := DialResolver("tcp", "ArithService", resolver.NewFile(path, 10*time.Millisecond))
+	assert.Equal(t, nil, err)
+	defer cc.Close()
+
+	resp := &pb.ArithResponse{}
+	err = cc.Call("ArithService.Add", &pb.ArithRequest{A: 20, B: 5}, resp)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, float64(25), resp.C)
+	assert.Equal(t, 1, connCount(server1))
+	assert.Equal(t, 0, connCount(server2))
+
+	// a new endpoint is dialed
+	writeHosts(fmt.Sprintf("%q, %q", addr1, addr2))
+	assert.Eventually(t, func() bool {
+		return connCount(server2) == 1
+	}, 2*time.Second, 10*time.Millisecond)
+
+	// and a removed one is closed
+	writeHosts(fmt.Sprintf("%q", addr2))
+	assert.Eventually(t, func() bool {
+		return connCount(server1) == 0
+	}, 2*time.Second, 10*time.Millisecond)
+	for i := 0; i < 4; i++ {
+		err = cc.Call("ArithService.Add", &pb.ArithRequest{A: 20, B: 5}, resp)
+		assert.Equal(t, nil, err)
+	}
+
+	_, err = DialResolver("tcp", "EchoService", resolver.NewFile(path, time.Second))
+	assert.NotEqual(t, nil, err)
+
+	// unreachable endpoints are redialed in the background
+	cc2, err := DialResolver("tcp", "ArithService", resolver.NewStatic("127.0.0.1:1"),
+		WithReconnectBackoff(10*time.Millisecond, 50*time.Millisecond))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, ErrUnavailable, cc2.Call("ArithService.Add", &pb.ArithRequest{A: 20, B: 5}, resp))
+	assert.Equal(t, nil, cc2.Close())
+}
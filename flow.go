@@ -0,0 +1,167 @@
+// Copyright 2022 <mzh.scnu@qq.com>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tinyrpc
+
+import (
+	"context"
+	"sync"
+
+	"github.com/zehuamama/tinyrpc/header"
+)
+
+// defaultStreamWindow is the number of messages a stream buffers by default
+const defaultStreamWindow = 4 * header.InitialWindow
+
+// WithStreamWindow sets how many received messages a stream buffers before
+// the sender has to wait for them to be read, it is at least header.InitialWindow.
+// The default is 64.
+func WithStreamWindow(n int) Option {
+	return func(o *options) {
+		o.streamWindow = n
+	}
+}
+
+func streamWindow(n int) int {
+	if n == 0 {
+		return defaultStreamWindow
+	}
+	if n < header.InitialWindow {
+		return header.InitialWindow
+	}
+	return n
+}
+
+// inbox queues the messages a stream has received until they are read,
+// the sender is granted more messages as they are read
+type inbox struct {
+	mutex    sync.Mutex // protects following
+	queue    [][]byte
+	err      error // returned once the queue is empty
+	size     int   // receive window
+	consumed int   // messages read since the last grant
+
+	notify chan struct{} // signaled when queue or err change
+}
+
+func newInbox(size int) *inbox {
+	return &inbox{size: size, notify: make(chan struct{}, 1)}
+}
+
+// push queues a message, it reports false if the sender has exceeded the window
+func (b *inbox) push(payload []byte) bool {
+	b.mutex.Lock()
+	if b.err != nil {
+		b.mutex.Unlock()
+		return true
+	}
+	if len(b.queue) >= b.size {
+		b.mutex.Unlock()
+		return false
+	}
+	b.queue = append(b.queue, payload)
+	b.mutex.Unlock()
+	b.signal()
+	return true
+}
+
+// close makes pop return err once the queue is empty,
+// the queued messages are dropped if discard is set
+func (b *inbox) close(err error, discard bool) {
+	b.mutex.Lock()
+	if b.err == nil {
+		b.err = err
+		if discard {
+			b.queue = nil
+		}
+	}
+	b.mutex.Unlock()
+	b.signal()
+}
+
+// pop returns the next message and how many messages should be granted to
+// the sender, it waits until a message arrives, the inbox is closed or ctx is done
+func (b *inbox) pop(ctx context.Context) (payload []byte, grant int, err error) {
+	for {
+		b.mutex.Lock()
+		if len(b.queue) > 0 {
+			payload = b.queue[0]
+			b.queue[0] = nil
+			b.queue = b.queue[1:]
+			b.consumed++
+			if b.err == nil && b.consumed >= b.size/2 {
+				grant = b.consumed
+				b.consumed = 0
+			}
+			b.mutex.Unlock()
+			return payload, grant, nil
+		}
+		err = b.err
+		b.mutex.Unlock()
+		if err != nil {
+			return nil, 0, err
+		}
+		select {
+		case <-b.notify:
+		case <-ctx.Done():
+			return nil, 0, ctx.Err()
+		}
+	}
+}
+
+func (b *inbox) signal() {
+	select {
+	case b.notify <- struct{}{}:
+	default:
+	}
+}
+
+// window counts the messages a stream may still send
+type window struct {
+	mutex   sync.Mutex // protects credits
+	credits int
+
+	ready chan struct{} // signaled when credits are granted
+}
+
+func newWindow(credits int) *window {
+	return &window{credits: credits, ready: make(chan struct{}, 1)}
+}
+
+// acquire takes a credit, it waits until one is granted or done is closed
+func (w *window) acquire(done <-chan struct{}) bool {
+	for {
+		w.mutex.Lock()
+		if w.credits > 0 {
+			w.credits--
+			more := w.credits > 0
+			w.mutex.Unlock()
+			if more {
+				// wake up another sender
+				w.signal()
+			}
+			return true
+		}
+		w.mutex.Unlock()
+		select {
+		case <-w.ready:
+		case <-done:
+			return false
+		}
+	}
+}
+
+func (w *window) grant(n int) {
+	w.mutex.Lock()
+	w.credits += n
+	w.mutex.Unlock()
+	w.signal()
+}
+
+func (w *window) signal() {
+	select {
+	case w.ready <- struct{}{}:
+	default:
+	}
+}
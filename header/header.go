@@ -7,14 +7,23 @@ package header
 import (
 	"encoding/binary"
 	"errors"
+	"sort"
 	"sync"
+	"time"
 
 	"github.com/zehuamama/tinyrpc/compressor"
 )
 
 const (
-	// MaxHeaderSize = 2 + 10 + 10 + 10 + 4 (10 refer to binary.MaxVarintLen64)
-	MaxHeaderSize = 36
+	// Magic is the prefix of every tinyrpc frame, traffic without it is rejected
+	Magic uint16 = 0x7472 // "tr"
+	// Version is the version of the frame and header format
+	Version byte = 1
+	// PrefixSize = 2 + 1, the size of magic and version
+	PrefixSize = 3
+
+	// MaxHeaderSize = 2 + 10 + 10 + 10 + 4 + 10 (10 refer to binary.MaxVarintLen64)
+	MaxHeaderSize = 46
 
 	Uint32Size = 4
 	Uint16Size = 2
@@ -22,12 +31,48 @@ const (
 
 var UnmarshalError = errors.New("an error occurred in Unmarshal")
 
+// Frame flags, carried by the Flags field of both headers
+const (
+	// FlagGoAway tells the client that the server is shutting down,
+	// no more requests should be sent on the connection
+	FlagGoAway uint32 = 1 << iota
+	// FlagStream marks a message of a stream, more frames with the same ID
+	// follow. The stream ends with a frame without it.
+	FlagStream
+	// FlagCancel tells the server that the client has abandoned the
+	// request with the same ID, the frame has no body
+	FlagCancel
+	// FlagEndStream tells the server that the client has sent the last
+	// message of the stream with the same ID, the frame has no body
+	FlagEndStream
+	// FlagWindowUpdate grants the peer Window more messages on the
+	// stream with the same ID, the frame has no body
+	FlagWindowUpdate
+	// FlagPing asks the peer to answer with a FlagPong frame with the same
+	// ID, either side sends it to learn whether the peer is alive, the
+	// frame has no body
+	FlagPing
+	// FlagPong answers a FlagPing frame, the frame has no body
+	FlagPong
+	// FlagOneWay marks a request whose caller does not wait for the
+	// reply, the server sends no response for it
+	FlagOneWay
+)
+
+// InitialWindow is the number of messages either side of a stream may
+// send before the receiver grants more with FlagWindowUpdate frames
+const InitialWindow = 16
+
 // RequestHeader request header structure looks like:
-// +--------------+----------------+----------+------------+----------+
-// | CompressType |      Method    |    ID    | RequestLen | Checksum |
-// +--------------+----------------+----------+------------+----------+
-// |    uint16    | uvarint+string |  uvarint |   uvarint  |  uint32  |
-// +--------------+----------------+----------+------------+----------+
+// +--------------+----------------+----------+------------+----------+---------+----------+---------+---------+
+// | CompressType |      Method    |    ID    | RequestLen | Checksum | Timeout | Metadata |  Flags  |  Window |
+// +--------------+----------------+----------+------------+----------+---------+----------+---------+---------+
+// |    uint16    | uvarint+string |  uvarint |   uvarint  |  uint32  | uvarint | metadata | uvarint | uvarint |
+// +--------------+----------------+----------+------------+----------+---------+----------+---------+---------+
+// The fields after Checksum are optional, they are only written up to the last
+// one which is set so that peers which do not know about them can still decode
+// the header. Metadata is a uvarint count followed by that many key/value pairs,
+// each of them encoded as uvarint+string.
 type RequestHeader struct {
 	sync.RWMutex
 	CompressType compressor.CompressType
@@ -35,6 +80,10 @@ type RequestHeader struct {
 	ID           uint64
 	RequestLen   uint32
 	Checksum     uint32
+	Timeout      time.Duration // how long the client waits for the response, 0 means no limit
+	Metadata     map[string]string
+	Flags        uint32
+	Window       uint32 // messages granted to the peer on top of its window
 }
 
 // Marshal will encode request header into a byte slice
@@ -42,8 +91,8 @@ func (r *RequestHeader) Marshal() []byte {
 	r.RLock()
 	defer r.RUnlock()
 	idx := 0
-	// MaxHeaderSize = 2 + 10 + len(string) + 10 + 10 + 4
-	header := make([]byte, MaxHeaderSize+len(r.Method))
+	// MaxHeaderSize = 2 + 10 + len(string) + 10 + 10 + 4 + 10
+	header := make([]byte, MaxHeaderSize+len(r.Method)+metadataSize(r.Metadata))
 
 	binary.LittleEndian.PutUint16(header[idx:], uint16(r.CompressType))
 	idx += Uint16Size
@@ -54,6 +103,19 @@ func (r *RequestHeader) Marshal() []byte {
 
 	binary.LittleEndian.PutUint32(header[idx:], r.Checksum)
 	idx += Uint32Size
+
+	if r.Timeout > 0 || len(r.Metadata) > 0 || r.Flags != 0 || r.Window != 0 {
+		idx += binary.PutUvarint(header[idx:], uint64(r.Timeout))
+	}
+	if len(r.Metadata) > 0 || r.Flags != 0 || r.Window != 0 {
+		idx += writeMetadata(header[idx:], r.Metadata)
+	}
+	if r.Flags != 0 || r.Window != 0 {
+		idx += binary.PutUvarint(header[idx:], uint64(r.Flags))
+	}
+	if r.Window != 0 {
+		idx += binary.PutUvarint(header[idx:], uint64(r.Window))
+	}
 	return header[:idx]
 }
 
@@ -85,7 +147,29 @@ func (r *RequestHeader) Unmarshal(data []byte) (err error) {
 	idx += size
 
 	r.Checksum = binary.LittleEndian.Uint32(data[idx:])
+	idx += Uint32Size
 
+	if idx < len(data) {
+		timeout, size := binary.Uvarint(data[idx:])
+		r.Timeout = time.Duration(timeout)
+		idx += size
+	}
+	if idx < len(data) {
+		r.Metadata, size, err = readMetadata(data[idx:])
+		if err != nil {
+			return
+		}
+		idx += size
+	}
+	if idx < len(data) {
+		flags, size := binary.Uvarint(data[idx:])
+		r.Flags = uint32(flags)
+		idx += size
+	}
+	if idx < len(data) {
+		window, _ := binary.Uvarint(data[idx:])
+		r.Window = uint32(window)
+	}
 	return
 }
 
@@ -105,14 +189,19 @@ func (r *RequestHeader) ResetHeader() {
 	r.Method = ""
 	r.CompressType = 0
 	r.RequestLen = 0
+	r.Timeout = 0
+	r.Metadata = nil
+	r.Flags = 0
+	r.Window = 0
 }
 
 // ResponseHeader request header structure looks like:
-// +--------------+---------+----------------+-------------+----------+
-// | CompressType |    ID   |      Error     | ResponseLen | Checksum |
-// +--------------+---------+----------------+-------------+----------+
-// |    uint16    | uvarint | uvarint+string |    uvarint  |  uint32  |
-// +--------------+---------+----------------+-------------+----------+
+// +--------------+---------+----------------+-------------+----------+---------+----------+---------+---------------+---------+
+// | CompressType |    ID   |      Error     | ResponseLen | Checksum |  Flags  | Metadata |   Code  |    Details    |  Window |
+// +--------------+---------+----------------+-------------+----------+---------+----------+---------+---------------+---------+
+// |    uint16    | uvarint | uvarint+string |    uvarint  |  uint32  | uvarint | metadata | uvarint | uvarint+bytes | uvarint |
+// +--------------+---------+----------------+-------------+----------+---------+----------+---------+---------------+---------+
+// The fields after Checksum are optional, see RequestHeader.
 type ResponseHeader struct {
 	sync.RWMutex
 	CompressType compressor.CompressType
@@ -120,6 +209,11 @@ type ResponseHeader struct {
 	Error        string
 	ResponseLen  uint32
 	Checksum     uint32
+	Flags        uint32
+	Metadata     map[string]string
+	Code         uint32 // status code of Error, 0 if Error carries no code
+	Details      []byte // structured details of Error
+	Window       uint32 // messages granted to the peer on top of its window
 }
 
 // Marshal will encode response header into a byte slice
@@ -127,7 +221,8 @@ func (r *ResponseHeader) Marshal() []byte {
 	r.RLock()
 	defer r.RUnlock()
 	idx := 0
-	header := make([]byte, MaxHeaderSize+len(r.Error)) // prevent panic
+	header := make([]byte, MaxHeaderSize+len(r.Error)+metadataSize(r.Metadata)+
+		3*binary.MaxVarintLen64+len(r.Details)) // prevent panic
 
 	binary.LittleEndian.PutUint16(header[idx:], uint16(r.CompressType))
 	idx += Uint16Size
@@ -138,6 +233,21 @@ func (r *ResponseHeader) Marshal() []byte {
 
 	binary.LittleEndian.PutUint32(header[idx:], r.Checksum)
 	idx += Uint32Size
+
+	hasStatus := r.Code != 0 || len(r.Details) > 0 || r.Window != 0
+	if r.Flags != 0 || len(r.Metadata) > 0 || hasStatus {
+		idx += binary.PutUvarint(header[idx:], uint64(r.Flags))
+	}
+	if len(r.Metadata) > 0 || hasStatus {
+		idx += writeMetadata(header[idx:], r.Metadata)
+	}
+	if hasStatus {
+		idx += binary.PutUvarint(header[idx:], uint64(r.Code))
+		idx += writeString(header[idx:], string(r.Details))
+	}
+	if r.Window != 0 {
+		idx += binary.PutUvarint(header[idx:], uint64(r.Window))
+	}
 	return header[:idx]
 }
 
@@ -169,6 +279,34 @@ func (r *ResponseHeader) Unmarshal(data []byte) (err error) {
 	idx += size
 
 	r.Checksum = binary.LittleEndian.Uint32(data[idx:])
+	idx += Uint32Size
+
+	if idx < len(data) {
+		flags, size := binary.Uvarint(data[idx:])
+		r.Flags = uint32(flags)
+		idx += size
+	}
+	if idx < len(data) {
+		r.Metadata, size, err = readMetadata(data[idx:])
+		if err != nil {
+			return
+		}
+		idx += size
+	}
+	if idx < len(data) {
+		code, size := binary.Uvarint(data[idx:])
+		r.Code = uint32(code)
+		idx += size
+		details, size := readString(data[idx:])
+		if len(details) > 0 {
+			r.Details = []byte(details)
+		}
+		idx += size
+	}
+	if idx < len(data) {
+		window, _ := binary.Uvarint(data[idx:])
+		r.Window = uint32(window)
+	}
 	return
 }
 
@@ -188,6 +326,11 @@ func (r *ResponseHeader) ResetHeader() {
 	r.CompressType = 0
 	r.Checksum = 0
 	r.ResponseLen = 0
+	r.Flags = 0
+	r.Metadata = nil
+	r.Code = 0
+	r.Details = nil
+	r.Window = 0
 }
 
 func readString(data []byte) (string, int) {
@@ -206,3 +349,49 @@ func writeString(data []byte, str string) int {
 	idx += len(str)
 	return idx
 }
+
+// metadataSize returns the maximum encoded size of md
+func metadataSize(md map[string]string) int {
+	size := binary.MaxVarintLen64
+	for k, v := range md {
+		size += 2*binary.MaxVarintLen64 + len(k) + len(v)
+	}
+	return size
+}
+
+// writeMetadata writes md with its keys sorted, so the encoding is stable
+func writeMetadata(data []byte, md map[string]string) int {
+	keys := make([]string, 0, len(md))
+	for k := range md {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	idx := binary.PutUvarint(data, uint64(len(keys)))
+	for _, k := range keys {
+		idx += writeString(data[idx:], k)
+		idx += writeString(data[idx:], md[k])
+	}
+	return idx
+}
+
+// readMetadata returns the metadata and the number of bytes it takes
+func readMetadata(data []byte) (map[string]string, int, error) {
+	count, idx := binary.Uvarint(data)
+	// every pair takes two bytes at least
+	if idx <= 0 || count > uint64(len(data)-idx)/2 {
+		return nil, 0, UnmarshalError
+	}
+	if count == 0 {
+		return nil, idx, nil
+	}
+	md := make(map[string]string)
+	for i := uint64(0); i < count; i++ {
+		k, size := readString(data[idx:])
+		idx += size
+		v, size := readString(data[idx:])
+		idx += size
+		md[k] = v
+	}
+	return md, idx, nil
+}
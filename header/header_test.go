@@ -8,6 +8,7 @@ import (
 	"github.com/zehuamama/tinyrpc/compressor"
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -26,6 +27,73 @@ func TestRequestHeader_Marshal(t *testing.T) {
 		0xa7, 0x61, 0x8a, 0x2, 0x6d, 0xa7, 0x31, 0xe5}, header.Marshal())
 }
 
+// TestRequestHeader_MarshalTimeout .
+func TestRequestHeader_MarshalTimeout(t *testing.T) {
+	header := &RequestHeader{
+		CompressType: 0,
+		Method:       "Add",
+		ID:           12455,
+		RequestLen:   266,
+		Checksum:     3845236589,
+		Timeout:      2 * time.Second,
+	}
+
+	assert.Equal(t, []byte{0x0, 0x0, 0x3, 0x41, 0x64, 0x64,
+		0xa7, 0x61, 0x8a, 0x2, 0x6d, 0xa7, 0x31, 0xe5,
+		0x80, 0xa8, 0xd6, 0xb9, 0x7}, header.Marshal())
+}
+
+// TestRequestHeader_MarshalMetadata .
+func TestRequestHeader_MarshalMetadata(t *testing.T) {
+	header := &RequestHeader{
+		Method:   "Add",
+		Metadata: map[string]string{"b": "2", "a": "1"},
+	}
+	data := header.Marshal()
+	assert.Equal(t, []byte{0x0, 0x0, 0x3, 0x41, 0x64, 0x64,
+		0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0,
+		0x2, 0x1, 0x61, 0x1, 0x31, 0x1, 0x62, 0x1, 0x32}, data)
+
+	h := &RequestHeader{}
+	assert.Equal(t, nil, h.Unmarshal(data))
+	assert.Equal(t, true, reflect.DeepEqual(header, h))
+
+	// the metadata count is larger than the remaining data
+	h = &RequestHeader{}
+	assert.Equal(t, UnmarshalError, h.Unmarshal(append(data[:13], 0xff, 0xff, 0x3)))
+}
+
+// TestRequestHeader_MarshalFlags .
+func TestRequestHeader_MarshalFlags(t *testing.T) {
+	header := &RequestHeader{
+		ID:    7,
+		Flags: FlagCancel,
+	}
+	data := header.Marshal()
+	assert.Equal(t, []byte{0x0, 0x0, 0x0, 0x7, 0x0, 0x0, 0x0, 0x0, 0x0,
+		0x0, 0x0, 0x4}, data)
+
+	h := &RequestHeader{}
+	assert.Equal(t, nil, h.Unmarshal(data))
+	assert.Equal(t, true, reflect.DeepEqual(header, h))
+}
+
+// TestRequestHeader_MarshalWindow .
+func TestRequestHeader_MarshalWindow(t *testing.T) {
+	header := &RequestHeader{
+		ID:     7,
+		Flags:  FlagWindowUpdate,
+		Window: 300,
+	}
+	data := header.Marshal()
+	assert.Equal(t, []byte{0x0, 0x0, 0x0, 0x7, 0x0, 0x0, 0x0, 0x0, 0x0,
+		0x0, 0x0, 0x10, 0xac, 0x2}, data)
+
+	h := &RequestHeader{}
+	assert.Equal(t, nil, h.Unmarshal(data))
+	assert.Equal(t, true, reflect.DeepEqual(header, h))
+}
+
 // TestRequestHeader_Unmarshal .
 func TestRequestHeader_Unmarshal(t *testing.T) {
 	type expect struct {
@@ -61,6 +129,20 @@ func TestRequestHeader_Unmarshal(t *testing.T) {
 			expect{&RequestHeader{},
 				UnmarshalError},
 		},
+		{
+			"test-4",
+			[]byte{0x0, 0x0, 0x3, 0x41, 0x64, 0x64,
+				0xa7, 0x61, 0x8a, 0x2, 0x6d, 0xa7, 0x31, 0xe5,
+				0x80, 0xa8, 0xd6, 0xb9, 0x7},
+			expect{&RequestHeader{
+				CompressType: 0,
+				Method:       "Add",
+				ID:           12455,
+				RequestLen:   266,
+				Checksum:     3845236589,
+				Timeout:      2 * time.Second,
+			}, nil},
+		},
 	}
 	for _, c := range cases {
 		t.Run(c.name, func(t *testing.T) {
@@ -80,6 +162,10 @@ func TestRequestHeader_ResetHeader(t *testing.T) {
 		ID:           12455,
 		RequestLen:   266,
 		Checksum:     3845236589,
+		Timeout:      time.Second,
+		Metadata:     map[string]string{"a": "1"},
+		Flags:        FlagStream,
+		Window:       1,
 	}
 	header.ResetHeader()
 	assert.Equal(t, true, reflect.DeepEqual(header, &RequestHeader{}))
@@ -99,6 +185,63 @@ func TestResponseHeader_Marshal(t *testing.T) {
 		0x72, 0x6f, 0x72, 0x8a, 0x2, 0x6d, 0xa7, 0x31, 0xe5}, header.Marshal())
 }
 
+// TestResponseHeader_MarshalFlags .
+func TestResponseHeader_MarshalFlags(t *testing.T) {
+	header := &ResponseHeader{
+		Flags: FlagGoAway,
+	}
+
+	assert.Equal(t, []byte{0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x1}, header.Marshal())
+}
+
+// TestResponseHeader_MarshalMetadata .
+func TestResponseHeader_MarshalMetadata(t *testing.T) {
+	header := &ResponseHeader{
+		ID:       1,
+		Metadata: map[string]string{"a": "1"},
+	}
+	data := header.Marshal()
+	assert.Equal(t, []byte{0x0, 0x0, 0x1, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0,
+		0x0, 0x1, 0x1, 0x61, 0x1, 0x31}, data)
+
+	h := &ResponseHeader{}
+	assert.Equal(t, nil, h.Unmarshal(data))
+	assert.Equal(t, true, reflect.DeepEqual(header, h))
+}
+
+// TestResponseHeader_MarshalStatus .
+func TestResponseHeader_MarshalStatus(t *testing.T) {
+	header := &ResponseHeader{
+		ID:      1,
+		Error:   "e",
+		Code:    5,
+		Details: []byte{0x8},
+	}
+	data := header.Marshal()
+	assert.Equal(t, []byte{0x0, 0x0, 0x1, 0x1, 0x65, 0x0, 0x0, 0x0, 0x0, 0x0,
+		0x0, 0x0, 0x5, 0x1, 0x8}, data)
+
+	h := &ResponseHeader{}
+	assert.Equal(t, nil, h.Unmarshal(data))
+	assert.Equal(t, true, reflect.DeepEqual(header, h))
+}
+
+// TestResponseHeader_MarshalWindow .
+func TestResponseHeader_MarshalWindow(t *testing.T) {
+	header := &ResponseHeader{
+		ID:     1,
+		Flags:  FlagWindowUpdate,
+		Window: 3,
+	}
+	data := header.Marshal()
+	assert.Equal(t, []byte{0x0, 0x0, 0x1, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0,
+		0x10, 0x0, 0x0, 0x0, 0x3}, data)
+
+	h := &ResponseHeader{}
+	assert.Equal(t, nil, h.Unmarshal(data))
+	assert.Equal(t, true, reflect.DeepEqual(header, h))
+}
+
 // TestResponseHeader_Unmarshal .
 func TestResponseHeader_Unmarshal(t *testing.T) {
 	type expect struct {
@@ -128,6 +271,13 @@ func TestResponseHeader_Unmarshal(t *testing.T) {
 			expect{&ResponseHeader{},
 				UnmarshalError},
 		},
+		{
+			"test-4",
+			[]byte{0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x1},
+			expect{&ResponseHeader{
+				Flags: FlagGoAway,
+			}, nil},
+		},
 		{
 			"test-3",
 			[]byte{0x0},
@@ -153,6 +303,11 @@ func TestResponseHeader_ResetHeader(t *testing.T) {
 		ID:           12455,
 		ResponseLen:  266,
 		Checksum:     3845236589,
+		Flags:        FlagGoAway,
+		Metadata:     map[string]string{"a": "1"},
+		Code:         5,
+		Details:      []byte{0x8},
+		Window:       1,
 	}
 	header.ResetHeader()
 	assert.Equal(t, true, reflect.DeepEqual(header, &ResponseHeader{}))
@@ -0,0 +1,102 @@
+// Copyright 2022 <mzh.scnu@qq.com>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tinyrpc
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/zehuamama/tinyrpc/codes"
+	"github.com/zehuamama/tinyrpc/health"
+	"github.com/zehuamama/tinyrpc/status"
+)
+
+// WithHealthCheck makes a ClientConn check the health of its endpoints every
+// interval by calling Health.Check with the service name, the empty name
+// stands for the server as a whole. An endpoint which is not serving the
+// service is left out of the load balancing until it is again. Checks which
+// fail for other reasons, e.g. because the server has no Health service,
+// leave the endpoint as it is.
+func WithHealthCheck(service string, interval time.Duration) Option {
+	return func(o *options) {
+		o.healthService = service
+		o.healthInterval = interval
+	}
+}
+
+// Health returns the health service of the server, the serving status
+// of a service is SERVING once it is registered. Shutdown and Close set
+// every service NOT_SERVING. An application may register its own service
+// named Health, it is served in place of this one then.
+func (s *Server) Health() *health.Server {
+	return s.health
+}
+
+// watchHealth checks the health of the endpoints every interval
+// until the ClientConn is closed
+func (cc *ClientConn) watchHealth() {
+	ticker := time.NewTicker(cc.healthInterval)
+	defer ticker.Stop()
+	for {
+		cc.checkHealth()
+		select {
+		case <-ticker.C:
+		case <-cc.done:
+			return
+		}
+	}
+}
+
+// checkHealth checks every endpoint over one of its connections
+func (cc *ClientConn) checkHealth() {
+	clients := make(map[string]*Client)
+	cc.mutex.Lock()
+	for _, sc := range cc.conns {
+		if _, ok := clients[sc.addr]; !ok && sc.client != nil && sc.client.available() {
+			clients[sc.addr] = sc.client
+		}
+	}
+	cc.mutex.Unlock()
+
+	var wg sync.WaitGroup
+	for addr, client := range clients {
+		wg.Add(1)
+		go func(addr string, client *Client) {
+			defer wg.Done()
+			if serving, ok := cc.check(client); ok {
+				cc.setServing(addr, serving)
+			}
+		}(addr, client)
+	}
+	wg.Wait()
+}
+
+// check reports whether the server serves the service, ok is false
+// if the check failed without telling
+func (cc *ClientConn) check(client *Client) (serving bool, ok bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), cc.healthInterval)
+	defer cancel()
+	resp := &health.HealthCheckResponse{}
+	err := client.CallContext(ctx, health.CheckMethod, &health.HealthCheckRequest{Service: cc.healthService}, resp)
+	switch {
+	case err == nil:
+		return resp.Status == health.HealthCheckResponse_SERVING, true
+	case status.Code(err) == codes.NotFound:
+		return false, true
+	}
+	return false, false
+}
+
+// setServing records whether the endpoint serves the service
+func (cc *ClientConn) setServing(addr string, serving bool) {
+	cc.mutex.Lock()
+	defer cc.mutex.Unlock()
+	if serving {
+		delete(cc.notServing, addr)
+	} else if !cc.closed && cc.has(addr) {
+		cc.notServing[addr] = true
+	}
+}
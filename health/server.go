@@ -0,0 +1,83 @@
+// Copyright 2022 <mzh.scnu@qq.com>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package health implements the health checking service every
+// tinyrpc server serves under the name Health
+package health
+
+import (
+	"sync"
+
+	"github.com/zehuamama/tinyrpc/codes"
+	"github.com/zehuamama/tinyrpc/status"
+)
+
+const (
+	// ServiceName is the name the service is registered with
+	ServiceName = "Health"
+	// CheckMethod is the service method of Check
+	CheckMethod = ServiceName + ".Check"
+)
+
+// Server implements the Health service, it keeps the serving status
+// of the services of a tinyrpc server
+type Server struct {
+	mutex    sync.Mutex // protects following
+	statuses map[string]HealthCheckResponse_ServingStatus
+	shutdown bool
+}
+
+// NewServer returns a health server with the server as a whole,
+// named by the empty string, serving
+func NewServer() *Server {
+	return &Server{
+		statuses: map[string]HealthCheckResponse_ServingStatus{
+			"": HealthCheckResponse_SERVING,
+		},
+	}
+}
+
+// Check returns the serving status of the service, it fails with
+// codes.NotFound if the service is unknown
+func (s *Server) Check(args *HealthCheckRequest, reply *HealthCheckResponse) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	st, ok := s.statuses[args.Service]
+	if !ok {
+		return status.Errorf(codes.NotFound, "health: unknown service %q", args.Service)
+	}
+	reply.Status = st
+	return nil
+}
+
+// SetServingStatus sets the serving status of the service, e.g. NOT_SERVING
+// while it warms up, it is ignored after Shutdown
+func (s *Server) SetServingStatus(service string, st HealthCheckResponse_ServingStatus) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if !s.shutdown {
+		s.statuses[service] = st
+	}
+}
+
+// Shutdown sets every service NOT_SERVING and ignores later
+// status changes, until Resume is called
+func (s *Server) Shutdown() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.shutdown = true
+	for service := range s.statuses {
+		s.statuses[service] = HealthCheckResponse_NOT_SERVING
+	}
+}
+
+// Resume sets every service SERVING and accepts status changes again
+func (s *Server) Resume() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.shutdown = false
+	for service := range s.statuses {
+		s.statuses[service] = HealthCheckResponse_SERVING
+	}
+}
@@ -0,0 +1,43 @@
+package health
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zehuamama/tinyrpc/codes"
+	"github.com/zehuamama/tinyrpc/status"
+)
+
+// TestServer .
+func TestServer(t *testing.T) {
+	s := NewServer()
+	check := func(service string) (HealthCheckResponse_ServingStatus, error) {
+		reply := &HealthCheckResponse{}
+		err := s.Check(&HealthCheckRequest{Service: service}, reply)
+		return reply.Status, err
+	}
+
+	st, err := check("")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, HealthCheckResponse_SERVING, st)
+	_, err = check("ArithService")
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	s.SetServingStatus("ArithService", HealthCheckResponse_NOT_SERVING)
+	st, err = check("ArithService")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, HealthCheckResponse_NOT_SERVING, st)
+
+	// changes are ignored after a shutdown
+	s.SetServingStatus("ArithService", HealthCheckResponse_SERVING)
+	s.Shutdown()
+	s.SetServingStatus("ArithService", HealthCheckResponse_SERVING)
+	st, _ = check("")
+	assert.Equal(t, HealthCheckResponse_NOT_SERVING, st)
+	st, _ = check("ArithService")
+	assert.Equal(t, HealthCheckResponse_NOT_SERVING, st)
+
+	s.Resume()
+	st, _ = check("ArithService")
+	assert.Equal(t, HealthCheckResponse_SERVING, st)
+}
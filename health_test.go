@@ -0,0 +1,110 @@
+package tinyrpc
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zehuamama/tinyrpc/codes"
+	"github.com/zehuamama/tinyrpc/health"
+	"github.com/zehuamama/tinyrpc/resolver"
+	"github.com/zehuamama/tinyrpc/status"
+	pb "github.com/zehuamama/tinyrpc/test.data/message"
+)
+
+// TestServer_Health .
+func TestServer_Health(t *testing.T) {
+	server := NewServer()
+	err := server.Register(new(pb.ArithService))
+	assert.Equal(t, nil, err)
+	client := serve(t, server)
+
+	check := func(service string) (health.HealthCheckResponse_ServingStatus, error) {
+		resp := &health.HealthCheckResponse{}
+		err := client.Call(health.CheckMethod, &health.HealthCheckRequest{Service: service}, resp)
+		return resp.Status, err
+	}
+	st, err := check("")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, health.HealthCheckResponse_SERVING, st)
+	st, err = check("ArithService")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, health.HealthCheckResponse_SERVING, st)
+	_, err = check("EchoService")
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	server.Health().SetServingStatus("ArithService", health.HealthCheckResponse_NOT_SERVING)
+	st, err = check("ArithService")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, health.HealthCheckResponse_NOT_SERVING, st)
+}
+
+// CustomHealth is a Health service of the application
+type CustomHealth struct{}
+
+func (CustomHealth) Check(args *health.HealthCheckRequest, reply *health.HealthCheckResponse) error {
+	reply.Status = health.HealthCheckResponse_NOT_SERVING
+	return nil
+}
+
+// TestServer_HealthReplaced .
+func TestServer_HealthReplaced(t *testing.T) {
+	server := NewServer()
+	err := server.RegisterName(health.ServiceName, CustomHealth{})
+	assert.Equal(t, nil, err)
+	// the name is taken by the service of the application now
+	err = server.RegisterName(health.ServiceName, CustomHealth{})
+	assert.EqualError(t, err, "rpc: service already defined: "+health.ServiceName)
+	client := serve(t, server)
+
+	resp := &health.HealthCheckResponse{}
+	err = client.Call(health.CheckMethod, &health.HealthCheckRequest{}, resp)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, health.HealthCheckResponse_NOT_SERVING, resp.Status)
+}
+
+// TestClientConn_HealthCheck .
+func TestClientConn_HealthCheck(t *testing.T) {
+	server1, addr1 := listenArith(t, "127.0.0.1:0")
+	defer server1.Close()
+	server2, addr2 := listenArith(t, "127.0.0.1:0")
+	defer server2.Close()
+
+	cc, err := DialResolver("tcp", "ArithService", resolver.NewStatic(addr1, addr2),
+		WithHealthCheck("ArithService", 10*time.Millisecond))
+	assert.Equal(t, nil, err)
+	defer cc.Close()
+
+	picks := func() map[string]int {
+		counts := make(map[string]int)
+		for i := 0; i < 4; i++ {
+			if e, err := cc.pick(context.Background(), "ArithService.Add"); err == nil {
+				counts[e.addr]++
+			}
+		}
+		return counts
+	}
+	assert.Equal(t, 2, len(picks()))
+
+	// an endpoint which is not serving is evicted
+	server1.Health().SetServingStatus("ArithService", health.HealthCheckResponse_NOT_SERVING)
+	assert.Eventually(t, func() bool {
+		counts := picks()
+		return len(counts) == 1 && counts[addr2] == 4
+	}, time.Second, 10*time.Millisecond)
+
+	resp := &pb.ArithResponse{}
+	server2.Health().SetServingStatus("ArithService", health.HealthCheckResponse_NOT_SERVING)
+	assert.Eventually(t, func() bool {
+		return cc.Call("ArithService.Add", &pb.ArithRequest{A: 20, B: 5}, resp) == ErrUnavailable
+	}, time.Second, 10*time.Millisecond)
+
+	// and is back once it serves again
+	server1.Health().SetServingStatus("ArithService", health.HealthCheckResponse_SERVING)
+	assert.Eventually(t, func() bool {
+		return cc.Call("ArithService.Add", &pb.ArithRequest{A: 20, B: 5}, resp) == nil
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, float64(25), resp.C)
+	assert.Equal(t, map[string]int{addr1: 4}, picks())
+}
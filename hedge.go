@@ -0,0 +1,166 @@
+// Copyright 2022 <mzh.scnu@qq.com>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tinyrpc
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/zehuamama/tinyrpc/codes"
+	"github.com/zehuamama/tinyrpc/status"
+)
+
+// HedgingPolicy configures how a ClientConn hedges the calls of the methods
+// declared idempotent with WithIdempotentMethods: when a call has not been
+// answered within Delay, a copy of it is sent over another connection,
+// preferably to another endpoint. The first response is used and the other
+// copies are cancelled, unless it is an error with codes.Unavailable or
+// codes.ResourceExhausted: such endpoints are down or overloaded, so the
+// other copies go on and the next one is sent right away if none is left.
+// Hedged calls are not retried.
+type HedgingPolicy struct {
+	// Delay is how long to wait for a response before sending the next copy,
+	// all the copies are sent at once if it is 0
+	Delay time.Duration
+	// MaxAttempts is the number of copies including the first one,
+	// it defaults to 2
+	MaxAttempts int
+}
+
+// WithHedging sets the hedging policy of a ClientConn
+func WithHedging(policy HedgingPolicy) Option {
+	return func(o *options) {
+		o.hedging = &policy
+	}
+}
+
+// hedgeGroup is shared by the copies of a hedged call, only the response
+// of the copy which claims it first is read into the reply
+type hedgeGroup struct {
+	winner int32 // the id of the winning copy, 0 before the first response
+}
+
+// hedgeAttempt is a copy of a hedged call
+type hedgeAttempt struct {
+	group *hedgeGroup
+	id    int32
+}
+
+// claim reports whether the response of the copy is the first one
+func (a *hedgeAttempt) claim() bool {
+	return atomic.CompareAndSwapInt32(&a.group.winner, 0, a.id)
+}
+
+// won reports whether the response of the copy has been read into the reply
+func (a *hedgeAttempt) won() bool {
+	return atomic.LoadInt32(&a.group.winner) == a.id
+}
+
+// hedgeRetryable reports whether an error response leaves the hedged call
+// to the other copies instead of claiming it
+func hedgeRetryable(err error) bool {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.ResourceExhausted:
+		return true
+	}
+	return false
+}
+
+type hedgeKey struct{}
+
+// hedgeFromContext returns the copy of a hedged call ctx belongs to, if any
+func hedgeFromContext(ctx context.Context) *hedgeAttempt {
+	a, _ := ctx.Value(hedgeKey{}).(*hedgeAttempt)
+	return a
+}
+
+// hedged reports whether the calls of serviceMethod are hedged
+func (cc *ClientConn) hedged(serviceMethod string) bool {
+	return cc.hedging != nil && cc.idempotent[serviceMethod]
+}
+
+// hedgeResult is the outcome of a copy of a hedged call
+type hedgeResult struct {
+	attempt *hedgeAttempt
+	err     error
+}
+
+// hedge sends copies of the call until one of them is answered. The copies
+// share reply, the client reads only the first response into it, so reply
+// is never written once hedge has returned.
+func (cc *ClientConn) hedge(ctx context.Context, serviceMethod string, args interface{}, reply interface{}) error {
+	maxAttempts := cc.hedging.MaxAttempts
+	if maxAttempts < 1 {
+		maxAttempts = 2
+	}
+	group := &hedgeGroup{}
+	attemptCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make(chan hedgeResult, maxAttempts)
+	used := make(map[string]bool)
+	launched, running := 0, 0
+	// launch sends the next copy, it returns the error of the first
+	// one if it can't be sent
+	launch := func() error {
+		launched++
+		e, err := cc.pickExcept(ctx, serviceMethod, used)
+		if err != nil {
+			return err
+		}
+		used[e.addr] = true
+		a := &hedgeAttempt{group: group, id: int32(launched)}
+		running++
+		go func() {
+			err := cc.callEndpoint(context.WithValue(attemptCtx, hedgeKey{}, a), e, serviceMethod, args, reply)
+			results <- hedgeResult{a, err}
+		}()
+		return nil
+	}
+
+	if err := launch(); err != nil {
+		return err
+	}
+	timer := time.NewTimer(cc.hedging.Delay)
+	defer timer.Stop()
+	var lastErr error
+	for {
+		select {
+		case r := <-results:
+			running--
+			if r.attempt.won() {
+				return r.err
+			}
+			lastErr = r.err
+			if running > 0 {
+				continue
+			}
+			// every copy failed before an answer, try the next one right away
+			for running == 0 && launched < maxAttempts {
+				if err := launch(); err != nil {
+					lastErr = err
+				}
+			}
+			if running == 0 {
+				return lastErr
+			}
+		case <-timer.C:
+			if launched < maxAttempts {
+				launch()
+				timer.Reset(cc.hedging.Delay)
+			}
+		case <-ctx.Done():
+			// a copy may be reading its response into reply right now
+			cancel()
+			for ; running > 0; running-- {
+				if r := <-results; r.attempt.won() {
+					return r.err
+				}
+			}
+			return ctx.Err()
+		}
+	}
+}
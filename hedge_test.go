@@ -0,0 +1,174 @@
+package tinyrpc
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zehuamama/tinyrpc/resolver"
+	pb "github.com/zehuamama/tinyrpc/test.data/message"
+)
+
+// HedgeService answers after a delay with its id
+type HedgeService struct {
+	id       float64
+	canceled chan struct{}
+
+	mutex sync.Mutex
+	delay time.Duration
+}
+
+func (s *HedgeService) Get(ctx context.Context, args *pb.ArithRequest, reply *pb.ArithResponse) error {
+	s.mutex.Lock()
+	delay := s.delay
+	s.mutex.Unlock()
+	select {
+	case <-time.After(delay):
+		reply.C = s.id
+		return nil
+	case <-ctx.Done():
+		s.canceled <- struct{}{}
+		return ctx.Err()
+	}
+}
+
+func (s *HedgeService) setDelay(d time.Duration) {
+	s.mutex.Lock()
+	s.delay = d
+	s.mutex.Unlock()
+}
+
+func (s *HedgeService) Put(ctx context.Context, args *pb.ArithRequest, reply *pb.ArithResponse) error {
+	return s.Get(ctx, args, reply)
+}
+
+// preferBalancer picks addr whenever it can
+type preferBalancer struct {
+	addr string
+}
+
+func (b preferBalancer) Pick(endpoints []Endpoint, info PickInfo) (Endpoint, error) {
+	for _, e := range endpoints {
+		if e.Addr() == b.addr {
+			return e, nil
+		}
+	}
+	return endpoints[0], nil
+}
+
+func listenHedge(t *testing.T, service *HedgeService, opts ...Option) string {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := NewServer(opts...)
+	err = server.Register(service)
+	assert.Equal(t, nil, err)
+	go server.Serve(lis)
+	t.Cleanup(func() { server.Close() })
+	return lis.Addr().String()
+}
+
+// TestClientConn_Hedging .
+func TestClientConn_Hedging(t *testing.T) {
+	slow := &HedgeService{id: 1, delay: 300 * time.Millisecond, canceled: make(chan struct{}, 10)}
+	fast := &HedgeService{id: 2, delay: 0, canceled: make(chan struct{}, 10)}
+	slowAddr := listenHedge(t, slow)
+	fastAddr := listenHedge(t, fast)
+
+	cc, err := DialResolver("tcp", "HedgeService", resolver.NewStatic(slowAddr, fastAddr),
+		WithBalancer(preferBalancer{slowAddr}),
+		WithHedging(HedgingPolicy{Delay: 20 * time.Millisecond}),
+		WithIdempotentMethods("HedgeService.Get"))
+	assert.Equal(t, nil, err)
+	defer cc.Close()
+
+	// the copy sent to the other endpoint answers first
+	resp := &pb.ArithResponse{}
+	start := time.Now()
+	err = cc.Call("HedgeService.Get", &pb.ArithRequest{}, resp)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, float64(2), resp.C)
+	assert.Less(t, int64(time.Since(start)), int64(200*time.Millisecond))
+	// and the slow one is cancelled
+	select {
+	case <-slow.canceled:
+	case <-time.After(time.Second):
+		t.Fatal("the slow copy was not cancelled")
+	}
+
+	// the first response wins if it comes within the delay
+	slow.setDelay(0)
+	err = cc.Call("HedgeService.Get", &pb.ArithRequest{}, resp)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, float64(1), resp.C)
+
+	// methods which are not idempotent are not hedged
+	slow.setDelay(100 * time.Millisecond)
+	start = time.Now()
+	err = cc.Call("HedgeService.Put", &pb.ArithRequest{}, resp)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, float64(1), resp.C)
+	assert.GreaterOrEqual(t, int64(time.Since(start)), int64(100*time.Millisecond))
+
+	// the caller's deadline still applies
+	slow.setDelay(time.Second)
+	fast.setDelay(time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err = cc.CallContext(ctx, "HedgeService.Get", &pb.ArithRequest{}, resp)
+	assert.Equal(t, context.DeadlineExceeded, err)
+}
+
+// TestClientConn_HedgingFastFailure .
+func TestClientConn_HedgingFastFailure(t *testing.T) {
+	overloaded := &HedgeService{id: 1, canceled: make(chan struct{}, 10)}
+	slow := &HedgeService{id: 2, delay: 100 * time.Millisecond, canceled: make(chan struct{}, 10)}
+	// every request to the overloaded endpoint is rejected at once
+	overloadedAddr := listenHedge(t, overloaded, WithMethodRateLimit("HedgeService.Get", RateLimit{}))
+	slowAddr := listenHedge(t, slow)
+
+	for _, delay := range []time.Duration{0, time.Minute} {
+		cc, err := DialResolver("tcp", "HedgeService", resolver.NewStatic(overloadedAddr, slowAddr),
+			WithBalancer(preferBalancer{overloadedAddr}),
+			WithHedging(HedgingPolicy{Delay: delay}),
+			WithIdempotentMethods("HedgeService.Get"))
+		assert.Equal(t, nil, err)
+
+		// the rejection comes first but the healthy endpoint answers the call
+		resp := &pb.ArithResponse{}
+		err = cc.Call("HedgeService.Get", &pb.ArithRequest{}, resp)
+		assert.Equal(t, nil, err)
+		assert.Equal(t, float64(2), resp.C)
+		cc.Close()
+	}
+}
+
+// TestClient_HedgeLoser .
+func TestClient_HedgeLoser(t *testing.T) {
+	service := &HedgeService{id: 1, canceled: make(chan struct{}, 10)}
+	server := NewServer()
+	err := server.Register(service)
+	assert.Equal(t, nil, err)
+	client := serve(t, server)
+
+	group := &hedgeGroup{}
+	first := &hedgeAttempt{group: group, id: 1}
+	second := &hedgeAttempt{group: group, id: 2}
+
+	resp := &pb.ArithResponse{}
+	ctx := context.WithValue(context.Background(), hedgeKey{}, second)
+	err = client.CallContext(ctx, "HedgeService.Get", &pb.ArithRequest{}, resp)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, second.won())
+
+	// the response of a copy answered later is discarded
+	resp = &pb.ArithResponse{}
+	ctx = context.WithValue(context.Background(), hedgeKey{}, first)
+	err = client.CallContext(ctx, "HedgeService.Get", &pb.ArithRequest{}, resp)
+	assert.Equal(t, context.Canceled, err)
+	assert.Equal(t, float64(0), resp.C)
+}
@@ -0,0 +1,106 @@
+// Copyright 2022 <mzh.scnu@qq.com>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tinyrpc
+
+import (
+	"context"
+	"time"
+)
+
+// UnaryServerInfo consists of various information about a call on the server side,
+// all fields are read-only for the interceptors
+type UnaryServerInfo struct {
+	// ServiceMethod is the name of the called method, like "ArithService.Add"
+	ServiceMethod string
+	// ID is the request ID chosen by the client
+	ID uint64
+	// Timeout is how long the client waits for the response, 0 means no limit
+	Timeout time.Duration
+}
+
+// UnaryHandler invokes the service method with the decoded args and returns its reply
+type UnaryHandler func(ctx context.Context, args interface{}) (reply interface{}, err error)
+
+// UnaryServerInterceptor intercepts the execution of a call on the server.
+// It can inspect and modify args, the reply and the error, handler must be
+// called to complete the call, an interceptor which returns without calling
+// handler short-circuits it.
+type UnaryServerInterceptor func(ctx context.Context, args interface{}, info *UnaryServerInfo,
+	handler UnaryHandler) (reply interface{}, err error)
+
+// WithServerInterceptors set server interceptors, they are executed in the
+// given order, the first one is the outermost
+func WithServerInterceptors(interceptors ...UnaryServerInterceptor) Option {
+	return func(o *options) {
+		o.serverInterceptors = append(o.serverInterceptors, interceptors...)
+	}
+}
+
+// chainUnaryServerInterceptors chains the interceptors into one, it returns nil
+// when there is no interceptor
+func chainUnaryServerInterceptors(interceptors []UnaryServerInterceptor) UnaryServerInterceptor {
+	switch len(interceptors) {
+	case 0:
+		return nil
+	case 1:
+		return interceptors[0]
+	}
+	return func(ctx context.Context, args interface{}, info *UnaryServerInfo,
+		handler UnaryHandler) (interface{}, error) {
+		return interceptors[0](ctx, args, info, chainUnaryHandler(interceptors, 0, info, handler))
+	}
+}
+
+func chainUnaryHandler(interceptors []UnaryServerInterceptor, curr int,
+	info *UnaryServerInfo, finalHandler UnaryHandler) UnaryHandler {
+	if curr == len(interceptors)-1 {
+		return finalHandler
+	}
+	return func(ctx context.Context, args interface{}) (interface{}, error) {
+		return interceptors[curr+1](ctx, args, info, chainUnaryHandler(interceptors, curr+1, info, finalHandler))
+	}
+}
+
+// UnaryInvoker sends the call to the server and waits for its reply
+type UnaryInvoker func(ctx context.Context, serviceMethod string, args interface{}, reply interface{}) error
+
+// UnaryClientInterceptor intercepts the execution of a call on the client.
+// It can inspect and modify the service method, args, reply and the returned
+// error, invoker must be called to send the call, calling it more than once
+// resends the call.
+type UnaryClientInterceptor func(ctx context.Context, serviceMethod string, args interface{}, reply interface{},
+	invoker UnaryInvoker) error
+
+// WithClientInterceptors set client interceptors, they are executed in the
+// given order, the first one is the outermost
+func WithClientInterceptors(interceptors ...UnaryClientInterceptor) Option {
+	return func(o *options) {
+		o.clientInterceptors = append(o.clientInterceptors, interceptors...)
+	}
+}
+
+// chainUnaryClientInterceptors chains the interceptors into one, it returns nil
+// when there is no interceptor
+func chainUnaryClientInterceptors(interceptors []UnaryClientInterceptor) UnaryClientInterceptor {
+	switch len(interceptors) {
+	case 0:
+		return nil
+	case 1:
+		return interceptors[0]
+	}
+	return func(ctx context.Context, serviceMethod string, args interface{}, reply interface{},
+		invoker UnaryInvoker) error {
+		return interceptors[0](ctx, serviceMethod, args, reply, chainUnaryInvoker(interceptors, 0, invoker))
+	}
+}
+
+func chainUnaryInvoker(interceptors []UnaryClientInterceptor, curr int, finalInvoker UnaryInvoker) UnaryInvoker {
+	if curr == len(interceptors)-1 {
+		return finalInvoker
+	}
+	return func(ctx context.Context, serviceMethod string, args interface{}, reply interface{}) error {
+		return interceptors[curr+1](ctx, serviceMethod, args, reply, chainUnaryInvoker(interceptors, curr+1, finalInvoker))
+	}
+}
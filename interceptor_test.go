@@ -0,0 +1,153 @@
+// Copyright 2022 <mzh.scnu@qq.com>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tinyrpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/rpc"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	pb "github.com/zehuamama/tinyrpc/test.data/message"
+)
+
+// PanicService .
+type PanicService struct{}
+
+// Panic always panics
+func (_ *PanicService) Panic(args *pb.ArithRequest, reply *pb.ArithResponse) error {
+	panic("boom")
+}
+
+// TestWithServerInterceptors .
+func TestWithServerInterceptors(t *testing.T) {
+	var trace []string
+	first := func(ctx context.Context, args interface{}, info *UnaryServerInfo,
+		handler UnaryHandler) (interface{}, error) {
+		trace = append(trace, "first:"+info.ServiceMethod)
+		reply, err := handler(ctx, args)
+		trace = append(trace, "first:done")
+		return reply, err
+	}
+	recovery := func(ctx context.Context, args interface{}, info *UnaryServerInfo,
+		handler UnaryHandler) (reply interface{}, err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				err = fmt.Errorf("panic: %v", r)
+			}
+		}()
+		return handler(ctx, args)
+	}
+	auth := func(ctx context.Context, args interface{}, info *UnaryServerInfo,
+		handler UnaryHandler) (interface{}, error) {
+		if info.ServiceMethod == "ArithService.Div" {
+			return nil, errors.New("permission denied")
+		}
+		if info.ServiceMethod == "ArithService.Sub" {
+			args.(*pb.ArithRequest).B = 0
+		}
+		reply, err := handler(ctx, args)
+		if info.ServiceMethod == "ArithService.Mul" {
+			reply.(*pb.ArithResponse).C *= 2
+		}
+		return reply, err
+	}
+
+	server := NewServer(WithServerInterceptors(first, recovery), WithServerInterceptors(auth))
+	assert.Equal(t, nil, server.Register(new(pb.ArithService)))
+	assert.Equal(t, nil, server.Register(new(PanicService)))
+	client := serve(t, server)
+
+	type expect struct {
+		reply float64
+		err   error
+	}
+	cases := []struct {
+		name          string
+		serviceMethod string
+		expect        expect
+	}{
+		{"test-1", "ArithService.Add", expect{25, nil}},
+		{"test-2", "ArithService.Sub", expect{20, nil}},
+		{"test-3", "ArithService.Mul", expect{200, nil}},
+		{"test-4", "ArithService.Div", expect{0, rpc.ServerError("permission denied")}},
+		{"test-5", "PanicService.Panic", expect{0, rpc.ServerError("panic: boom")}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			trace = nil
+			reply := &pb.ArithResponse{}
+			err := client.Call(c.serviceMethod, &pb.ArithRequest{A: 20, B: 5}, reply)
+			assert.Equal(t, c.expect.err, err)
+			assert.Equal(t, c.expect.reply, reply.C)
+			assert.Equal(t, []string{"first:" + c.serviceMethod, "first:done"}, trace)
+		})
+	}
+}
+
+// TestWithClientInterceptors .
+func TestWithClientInterceptors(t *testing.T) {
+	var trace []string
+	first := func(ctx context.Context, serviceMethod string, args interface{}, reply interface{},
+		invoker UnaryInvoker) error {
+		trace = append(trace, "first:"+serviceMethod)
+		err := invoker(ctx, serviceMethod, args, reply)
+		trace = append(trace, "first:done")
+		return err
+	}
+	rewrite := func(ctx context.Context, serviceMethod string, args interface{}, reply interface{},
+		invoker UnaryInvoker) error {
+		switch serviceMethod {
+		case "Arith.Plus":
+			serviceMethod = "ArithService.Add"
+		case "ArithService.Sub":
+			args = &pb.ArithRequest{A: 100, B: 1}
+		}
+		err := invoker(ctx, serviceMethod, args, reply)
+		if err != nil {
+			return errors.New("rewritten: " + err.Error())
+		}
+		return nil
+	}
+
+	server := NewServer()
+	assert.Equal(t, nil, server.Register(new(pb.ArithService)))
+	client := serve(t, server, WithClientInterceptors(first), WithClientInterceptors(rewrite))
+
+	type expect struct {
+		reply float64
+		err   error
+	}
+	cases := []struct {
+		name          string
+		serviceMethod string
+		async         bool
+		arg           *pb.ArithRequest
+		expect        expect
+	}{
+		{"test-1", "Arith.Plus", false, &pb.ArithRequest{A: 20, B: 5}, expect{25, nil}},
+		{"test-2", "ArithService.Sub", false, &pb.ArithRequest{A: 20, B: 5}, expect{99, nil}},
+		{"test-3", "ArithService.Div", false, &pb.ArithRequest{A: 20, B: 0},
+			expect{0, errors.New("rewritten: divided is zero")}},
+		{"test-4", "Arith.Plus", true, &pb.ArithRequest{A: 20, B: 5}, expect{25, nil}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			trace = nil
+			reply := &pb.ArithResponse{}
+			var err error
+			if c.async {
+				err = (<-client.AsyncCall(c.serviceMethod, c.arg, reply)).Error
+			} else {
+				err = client.Call(c.serviceMethod, c.arg, reply)
+			}
+			assert.Equal(t, c.expect.err, err)
+			assert.Equal(t, c.expect.reply, reply.C)
+			assert.Equal(t, []string{"first:" + c.serviceMethod, "first:done"}, trace)
+		})
+	}
+}
@@ -0,0 +1,169 @@
+// Copyright 2022 <mzh.scnu@qq.com>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tinyrpc
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"github.com/zehuamama/tinyrpc/compressor"
+	"github.com/zehuamama/tinyrpc/header"
+)
+
+// ErrKeepaliveTimeout is the error of the pending calls of a client whose
+// connection has been closed because the server stopped answering pings
+var ErrKeepaliveTimeout = errors.New("tinyrpc: keepalive timeout, the peer is not responding")
+
+// defaultKeepaliveTimeout is how long a ping is waited for if
+// WithKeepalive is given a non-positive timeout
+const defaultKeepaliveTimeout = 20 * time.Second
+
+// WithKeepalive makes the client or the server ping the peer once nothing
+// has been received from it for interval, the connection is closed if still
+// nothing is received within timeout after the ping. A non-positive timeout
+// means 20 seconds. Either side answers pings whether it sends them or not.
+// Keepalive is off by default.
+func WithKeepalive(interval, timeout time.Duration) Option {
+	if timeout <= 0 {
+		timeout = defaultKeepaliveTimeout
+	}
+	return func(o *options) {
+		o.keepaliveInterval = interval
+		o.keepaliveTimeout = timeout
+	}
+}
+
+// WithIdleTimeout makes the server close the connections which have had no
+// calls in flight for d, the client is told with a GOAWAY frame first.
+// Idle connections are kept by default.
+func WithIdleTimeout(d time.Duration) Option {
+	return func(o *options) {
+		o.idleTimeout = d
+	}
+}
+
+// liveness records when a frame was last received from the peer
+type liveness struct {
+	last int64 // UnixNano, accessed atomically
+}
+
+func newLiveness() *liveness {
+	return &liveness{last: time.Now().UnixNano()}
+}
+
+func (l *liveness) touch() {
+	atomic.StoreInt64(&l.last, time.Now().UnixNano())
+}
+
+func (l *liveness) lastRead() time.Time {
+	return time.Unix(0, atomic.LoadInt64(&l.last))
+}
+
+// keepalive pings the peer whenever nothing has been received for interval,
+// dead is called if nothing is received within timeout after a ping.
+// It returns when ctx is done or the peer is dead.
+func keepalive(ctx context.Context, l *liveness, interval, timeout time.Duration, ping func() error, dead func()) {
+	timer := time.NewTimer(interval)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+		if wait := interval - time.Since(l.lastRead()); wait > 0 {
+			timer.Reset(wait)
+			continue
+		}
+		pinged := time.Now()
+		if err := ping(); err != nil {
+			dead()
+			return
+		}
+		timer.Reset(timeout)
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+		if !l.lastRead().After(pinged) {
+			dead()
+			return
+		}
+		timer.Reset(interval)
+	}
+}
+
+// keepalive watches the server until it is gone
+func (c *Client) keepalive(interval, timeout time.Duration) {
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-c.done
+		cancel()
+	}()
+	ping := func() error {
+		return c.writeStreamFrame(0, header.FlagPing, 0, nil)
+	}
+	keepalive(ctx, c.live, interval, timeout, ping, func() {
+		c.mutex.Lock()
+		c.fatal = ErrKeepaliveTimeout
+		c.mutex.Unlock()
+		c.codec.Close()
+	})
+}
+
+// keepalive watches the client of c until ctx is done
+func (s *Server) keepalive(ctx context.Context, c *serverConn) {
+	ping := func() error {
+		return s.writeControl(c, 0, 0, header.FlagPing)
+	}
+	keepalive(ctx, c.live, s.keepaliveInterval, s.keepaliveTimeout, ping, func() {
+		log.Println("tinyrpc: closing connection: keepalive timeout")
+		c.cc.Close()
+	})
+}
+
+// reapIdle drains c once it has had no calls in flight for the idle
+// timeout of the server, it returns when ctx is done
+func (s *Server) reapIdle(ctx context.Context, c *serverConn) {
+	timer := time.NewTimer(s.idleTimeout)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+		c.mu.Lock()
+		wait := s.idleTimeout
+		if c.active == 0 {
+			wait -= time.Since(c.idleSince)
+		}
+		c.mu.Unlock()
+		if wait <= 0 {
+			c.drain()
+			return
+		}
+		timer.Reset(wait)
+	}
+}
+
+// writeControl writes a frame without body to the client of c
+func (s *Server) writeControl(c *serverConn, id uint64, compressType compressor.CompressType, flags uint32) error {
+	h := header.ResponsePool.Get().(*header.ResponseHeader)
+	defer func() {
+		h.ResetHeader()
+		header.ResponsePool.Put(h)
+	}()
+	h.ID = id
+	h.CompressType = compressType
+	h.Flags = flags
+	c.sending.Lock()
+	defer c.sending.Unlock()
+	return c.cc.WriteResponse(h, nil)
+}
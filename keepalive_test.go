@@ -0,0 +1,100 @@
+package tinyrpc
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	pb "github.com/zehuamama/tinyrpc/test.data/message"
+)
+
+// TestClient_Keepalive .
+func TestClient_Keepalive(t *testing.T) {
+	// a peer which reads everything and never answers, like a half-open connection
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Equal(t, nil, err)
+	defer lis.Close()
+	go func() {
+		conn, err := lis.Accept()
+		if err == nil {
+			io.Copy(io.Discard, conn)
+		}
+	}()
+	conn, err := net.Dial("tcp", lis.Addr().String())
+	assert.Equal(t, nil, err)
+	client := NewClient(conn, WithKeepalive(20*time.Millisecond, 30*time.Millisecond))
+	defer client.Close()
+
+	call := client.AsyncCall("ArithService.Add", &pb.ArithRequest{A: 20, B: 5}, &pb.ArithResponse{})
+	select {
+	case call := <-call:
+		assert.Equal(t, ErrKeepaliveTimeout, call.Error)
+		assert.Equal(t, true, IsTransportError(call.Error))
+	case <-time.After(time.Second):
+		t.Fatal("the call should fail once the keepalive times out")
+	}
+}
+
+// TestKeepalive_Healthy .
+func TestKeepalive_Healthy(t *testing.T) {
+	server := NewServer(WithKeepalive(10*time.Millisecond, 50*time.Millisecond))
+	err := server.Register(new(pb.ArithService))
+	assert.Equal(t, nil, err)
+	// the client answers the pings of the server and the other way round
+	client := serve(t, server, WithKeepalive(10*time.Millisecond, 50*time.Millisecond))
+	plain := serve(t, server)
+	// a zero timeout does not close the connection right after a ping
+	zero := serve(t, server, WithKeepalive(10*time.Millisecond, 0))
+
+	time.Sleep(200 * time.Millisecond)
+	resp := &pb.ArithResponse{}
+	err = client.Call("ArithService.Add", &pb.ArithRequest{A: 20, B: 5}, resp)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, float64(25), resp.C)
+	err = plain.Call("ArithService.Add", &pb.ArithRequest{A: 20, B: 5}, resp)
+	assert.Equal(t, nil, err)
+	err = zero.Call("ArithService.Add", &pb.ArithRequest{A: 20, B: 5}, resp)
+	assert.Equal(t, nil, err)
+}
+
+// TestServer_Keepalive .
+func TestServer_Keepalive(t *testing.T) {
+	server := NewServer(WithKeepalive(20*time.Millisecond, 30*time.Millisecond))
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Equal(t, nil, err)
+	go server.Serve(lis)
+	defer server.Close()
+
+	// a client which never answers is dropped
+	conn, err := net.Dial("tcp", lis.Addr().String())
+	assert.Equal(t, nil, err)
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, err = io.Copy(io.Discard, conn)
+	assert.Equal(t, nil, err)
+}
+
+// TestServer_IdleTimeout .
+func TestServer_IdleTimeout(t *testing.T) {
+	server := NewServer(WithIdleTimeout(50 * time.Millisecond))
+	block := newBlockService()
+	err := server.Register(block)
+	assert.Equal(t, nil, err)
+	client := serve(t, server)
+
+	// a call in flight keeps the connection
+	call := client.AsyncCall("BlockService.Block", &pb.ArithRequest{A: 1}, &pb.ArithResponse{})
+	<-block.started
+	time.Sleep(100 * time.Millisecond)
+	close(block.release)
+	assert.Equal(t, nil, (<-call).Error)
+
+	select {
+	case <-client.done:
+	case <-time.After(time.Second):
+		t.Fatal("the idle connection should be closed")
+	}
+	assert.Equal(t, false, client.available())
+}
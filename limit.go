@@ -0,0 +1,169 @@
+// Copyright 2022 <mzh.scnu@qq.com>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tinyrpc
+
+import (
+	"context"
+
+	"github.com/zehuamama/tinyrpc/codes"
+	"github.com/zehuamama/tinyrpc/status"
+)
+
+var (
+	// errServerBusy is sent when all handlers of the server are busy
+	errServerBusy = status.Error(codes.ResourceExhausted, "tinyrpc: too many concurrent requests")
+	// errConnBusy is sent when a connection has too many requests in flight
+	errConnBusy = status.Error(codes.ResourceExhausted, "tinyrpc: too many requests in flight on the connection")
+	// errQueueFull is sent when a connection has too many requests waiting for a slot
+	errQueueFull = status.Error(codes.ResourceExhausted, "tinyrpc: too many requests queued on the connection")
+)
+
+// defaultMaxQueuedPerConn is how many requests of a connection may wait for a slot by default
+const defaultMaxQueuedPerConn = 64
+
+// WithMaxConcurrentHandlers limits how many requests the server handles at
+// the same time over all connections, a stream counts until it ends.
+// Requests over the limit fail with codes.ResourceExhausted unless
+// WithQueueOnLimit is used. There is no limit by default.
+func WithMaxConcurrentHandlers(n int) Option {
+	return func(o *options) {
+		o.maxHandlers = n
+	}
+}
+
+// WithMaxInFlightPerConn limits how many requests of a single connection
+// are handled at the same time, a stream counts until it ends. Requests
+// over the limit fail with codes.ResourceExhausted unless WithQueueOnLimit
+// is used. There is no limit by default.
+func WithMaxInFlightPerConn(n int) Option {
+	return func(o *options) {
+		o.maxConnInFlight = n
+	}
+}
+
+// WithQueueOnLimit makes requests over the limits wait until a slot is free
+// or their deadline is exceeded instead of failing. A queued request waits
+// in its own goroutine along with its decoded arguments, so the connection
+// keeps being read and the streams, cancellations and pings of its other
+// calls are not held up. At most WithMaxQueuedPerConn requests of a
+// connection wait, the ones over it fail with codes.ResourceExhausted.
+func WithQueueOnLimit() Option {
+	return func(o *options) {
+		o.queueOnLimit = true
+	}
+}
+
+// WithMaxQueuedPerConn limits how many requests of a single connection wait
+// for a slot with WithQueueOnLimit, requests over it fail with
+// codes.ResourceExhausted. The default is 64.
+func WithMaxQueuedPerConn(n int) Option {
+	return func(o *options) {
+		o.maxConnQueued = n
+	}
+}
+
+func maxConnQueued(n int) int {
+	if n <= 0 {
+		return defaultMaxQueuedPerConn
+	}
+	return n
+}
+
+// semaphore limits concurrency, a nil semaphore has no limit
+type semaphore chan struct{}
+
+func newSemaphore(n int) semaphore {
+	if n <= 0 {
+		return nil
+	}
+	return make(semaphore, n)
+}
+
+// tryAcquire takes a slot if one is free
+func (s semaphore) tryAcquire() bool {
+	if s == nil {
+		return true
+	}
+	select {
+	case s <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+// acquire waits for a slot until ctx is done
+func (s semaphore) acquire(ctx context.Context) error {
+	if s == nil {
+		return nil
+	}
+	select {
+	case s <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s semaphore) release() {
+	if s != nil {
+		<-s
+	}
+}
+
+// admit takes a slot of the connection for req without blocking the read
+// loop. If there is none, it reports whether req has taken a place in the
+// queue of the connection instead or returns the error to answer req with.
+func (s *Server) admit(c *serverConn) (queued bool, err error) {
+	if c.inFlight.tryAcquire() {
+		return false, nil
+	}
+	if !s.queueOnLimit {
+		return false, errConnBusy
+	}
+	if !c.queue.tryAcquire() {
+		return false, errQueueFull
+	}
+	return true, nil
+}
+
+// acquire takes the slots of the connection and of the server for req, it
+// returns the error to answer req with if the request can not be handled.
+// queued tells whether admit has put req in the queue of the connection,
+// req gives its place back once it is done waiting.
+func (s *Server) acquire(c *serverConn, req *request, queued bool) error {
+	if queued {
+		defer c.queue.release()
+		if err := c.inFlight.acquire(req.ctx); err != nil {
+			return status.FromContextError(err).Err()
+		}
+	}
+	if s.handlers.tryAcquire() {
+		return nil
+	}
+	err := errServerBusy
+	if s.queueOnLimit {
+		err = s.waitHandler(c, req, queued)
+	}
+	if err != nil {
+		c.inFlight.release()
+	}
+	return err
+}
+
+// waitHandler waits for a slot of the server, req takes a place in the
+// queue of the connection unless it already holds one
+func (s *Server) waitHandler(c *serverConn, req *request, queued bool) error {
+	if !queued {
+		if !c.queue.tryAcquire() {
+			return errQueueFull
+		}
+		defer c.queue.release()
+	}
+	if err := s.handlers.acquire(req.ctx); err != nil {
+		return status.FromContextError(err).Err()
+	}
+	return nil
+}
@@ -0,0 +1,162 @@
+package tinyrpc
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zehuamama/tinyrpc/codes"
+	"github.com/zehuamama/tinyrpc/status"
+	pb "github.com/zehuamama/tinyrpc/test.data/message"
+)
+
+type BlockService struct {
+	started chan struct{}
+	release chan struct{}
+}
+
+func newBlockService() *BlockService {
+	return &BlockService{started: make(chan struct{}, 10), release: make(chan struct{})}
+}
+
+func (s *BlockService) Block(args *pb.ArithRequest, reply *pb.ArithResponse) error {
+	s.started <- struct{}{}
+	<-s.release
+	reply.C = args.A
+	return nil
+}
+
+// TestServer_MaxInFlightPerConn .
+func TestServer_MaxInFlightPerConn(t *testing.T) {
+	server := NewServer(WithMaxInFlightPerConn(1))
+	block := newBlockService()
+	err := server.Register(block)
+	assert.Equal(t, nil, err)
+	client := serve(t, server)
+	other := serve(t, server)
+
+	first := client.AsyncCall("BlockService.Block", &pb.ArithRequest{A: 1}, &pb.ArithResponse{})
+	<-block.started
+
+	err = client.Call("BlockService.Block", &pb.ArithRequest{A: 2}, &pb.ArithResponse{})
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+
+	// other connections are not affected
+	second := other.AsyncCall("BlockService.Block", &pb.ArithRequest{A: 3}, &pb.ArithResponse{})
+	<-block.started
+
+	close(block.release)
+	assert.Equal(t, nil, (<-first).Error)
+	assert.Equal(t, nil, (<-second).Error)
+	resp := &pb.ArithResponse{}
+	err = client.Call("BlockService.Block", &pb.ArithRequest{A: 4}, resp)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, float64(4), resp.C)
+}
+
+// TestServer_MaxConcurrentHandlers .
+func TestServer_MaxConcurrentHandlers(t *testing.T) {
+	server := NewServer(WithMaxConcurrentHandlers(1))
+	block := newBlockService()
+	err := server.Register(block)
+	assert.Equal(t, nil, err)
+	client := serve(t, server)
+	other := serve(t, server)
+
+	first := client.AsyncCall("BlockService.Block", &pb.ArithRequest{A: 1}, &pb.ArithResponse{})
+	<-block.started
+
+	err = other.Call("BlockService.Block", &pb.ArithRequest{A: 2}, &pb.ArithResponse{})
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+
+	close(block.release)
+	assert.Equal(t, nil, (<-first).Error)
+	err = other.Call("BlockService.Block", &pb.ArithRequest{A: 3}, &pb.ArithResponse{})
+	assert.Equal(t, nil, err)
+}
+
+// TestServer_QueueOnLimit .
+func TestServer_QueueOnLimit(t *testing.T) {
+	server := NewServer(WithMaxConcurrentHandlers(1), WithMaxInFlightPerConn(2), WithQueueOnLimit())
+	block := newBlockService()
+	err := server.Register(block)
+	assert.Equal(t, nil, err)
+	client := serve(t, server)
+	other := serve(t, server)
+
+	first := client.AsyncCall("BlockService.Block", &pb.ArithRequest{A: 1}, &pb.ArithResponse{})
+	<-block.started
+
+	// the queued call gives up when its deadline is exceeded
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err = other.CallContext(ctx, "BlockService.Block", &pb.ArithRequest{A: 2}, &pb.ArithResponse{})
+	assert.Equal(t, context.DeadlineExceeded, err)
+
+	resp := &pb.ArithResponse{}
+	second := other.AsyncCall("BlockService.Block", &pb.ArithRequest{A: 3}, resp)
+	select {
+	case <-block.started:
+		t.Fatal("the call should be queued")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(block.release)
+	assert.Equal(t, nil, (<-first).Error)
+	assert.Equal(t, nil, (<-second).Error)
+	assert.Equal(t, float64(3), resp.C)
+}
+
+// TestServer_MaxQueuedPerConn .
+func TestServer_MaxQueuedPerConn(t *testing.T) {
+	server := NewServer(WithMaxInFlightPerConn(1), WithQueueOnLimit(), WithMaxQueuedPerConn(1))
+	block := newBlockService()
+	err := server.Register(block)
+	assert.Equal(t, nil, err)
+	client := serve(t, server)
+
+	first := client.AsyncCall("BlockService.Block", &pb.ArithRequest{A: 1}, &pb.ArithResponse{})
+	<-block.started
+	second := client.AsyncCall("BlockService.Block", &pb.ArithRequest{A: 2}, &pb.ArithResponse{})
+
+	// the queue of the connection is full
+	err = client.Call("BlockService.Block", &pb.ArithRequest{A: 3}, &pb.ArithResponse{})
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+
+	close(block.release)
+	assert.Equal(t, nil, (<-first).Error)
+	assert.Equal(t, nil, (<-second).Error)
+	err = client.Call("BlockService.Block", &pb.ArithRequest{A: 4}, &pb.ArithResponse{})
+	assert.Equal(t, nil, err)
+}
+
+// TestServer_QueueOnLimitStream .
+func TestServer_QueueOnLimitStream(t *testing.T) {
+	server := NewServer(WithMaxInFlightPerConn(1), WithQueueOnLimit())
+	err := server.Register(&BidiService{})
+	assert.Equal(t, nil, err)
+	err = server.Register(new(pb.ArithService))
+	assert.Equal(t, nil, err)
+	client := serve(t, server)
+
+	stream, err := client.NewBidiStream(context.Background(), "BidiService.Sum")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, stream.Send(&pb.ArithRequest{A: 1}))
+
+	// the call waits for the stream, whose frames are still read meanwhile
+	resp := &pb.ArithResponse{}
+	queued := client.AsyncCall("ArithService.Add", &pb.ArithRequest{A: 2, B: 3}, resp)
+	select {
+	case <-queued:
+		t.Fatal("the call should be queued")
+	case <-time.After(50 * time.Millisecond):
+	}
+	assert.Equal(t, nil, stream.Send(&pb.ArithRequest{A: 2}))
+	sum := &pb.ArithResponse{}
+	assert.Equal(t, nil, stream.CloseAndRecv(sum))
+	assert.Equal(t, float64(3), sum.C)
+
+	assert.Equal(t, nil, (<-queued).Error)
+	assert.Equal(t, float64(5), resp.C)
+}
@@ -0,0 +1,55 @@
+// Copyright 2022 <mzh.scnu@qq.com>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tinyrpc
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/zehuamama/tinyrpc/metadata"
+)
+
+// NotServerContextError refers to a context which does not belong to a call served by Server
+var NotServerContextError = errors.New("tinyrpc: context does not belong to a server call")
+
+type responseMetadataKey struct{}
+type responseMetadataCaptureKey struct{}
+
+// responseMetadata collects the response metadata set by handlers and interceptors
+type responseMetadata struct {
+	mutex sync.Mutex
+	md    metadata.MD
+}
+
+func (r *responseMetadata) get() metadata.MD {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.md
+}
+
+// SetResponseMetadata merges md into the metadata which is sent back to the client,
+// ctx must be the context passed to a service method or a server interceptor
+func SetResponseMetadata(ctx context.Context, md metadata.MD) error {
+	r, ok := ctx.Value(responseMetadataKey{}).(*responseMetadata)
+	if !ok {
+		return NotServerContextError
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.md = metadata.Join(r.md, md)
+	return nil
+}
+
+// NewResponseMetadataContext returns a context which makes the client store
+// the metadata of the response into md when a call made with it completes
+func NewResponseMetadataContext(ctx context.Context, md *metadata.MD) context.Context {
+	return context.WithValue(ctx, responseMetadataCaptureKey{}, md)
+}
+
+func responseMetadataCapture(ctx context.Context) *metadata.MD {
+	md, _ := ctx.Value(responseMetadataCaptureKey{}).(*metadata.MD)
+	return md
+}
@@ -0,0 +1,108 @@
+// Copyright 2022 <mzh.scnu@qq.com>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package metadata
+
+import (
+	"context"
+	"fmt"
+)
+
+// MD is a mapping from metadata keys to values
+type MD map[string]string
+
+// New creates an MD from a given key-value map
+func New(m map[string]string) MD {
+	md := make(MD, len(m))
+	for k, v := range m {
+		md[k] = v
+	}
+	return md
+}
+
+// Pairs returns an MD formed by the mapping of key, value ...
+// Pairs panics if len(kv) is odd
+func Pairs(kv ...string) MD {
+	if len(kv)%2 == 1 {
+		panic(fmt.Sprintf("metadata: Pairs got the odd number of input pairs for metadata: %d", len(kv)))
+	}
+	md := make(MD, len(kv)/2)
+	for i := 0; i < len(kv); i += 2 {
+		md[kv[i]] = kv[i+1]
+	}
+	return md
+}
+
+// Len returns the number of items in md
+func (md MD) Len() int {
+	return len(md)
+}
+
+// Get obtains the value for a given key, "" is returned when the key is absent
+func (md MD) Get(key string) string {
+	return md[key]
+}
+
+// Set sets the value of a given key
+func (md MD) Set(key, value string) {
+	md[key] = value
+}
+
+// Delete removes the value of a given key
+func (md MD) Delete(key string) {
+	delete(md, key)
+}
+
+// Copy returns a copy of md
+func (md MD) Copy() MD {
+	return New(md)
+}
+
+// Join joins any number of mds into a single MD,
+// a later value of the same key overrides an earlier one
+func Join(mds ...MD) MD {
+	out := MD{}
+	for _, md := range mds {
+		for k, v := range md {
+			out[k] = v
+		}
+	}
+	return out
+}
+
+type mdIncomingKey struct{}
+type mdOutgoingKey struct{}
+
+// NewIncomingContext creates a new context with incoming md attached,
+// it is used by the server for the metadata of a request
+func NewIncomingContext(ctx context.Context, md MD) context.Context {
+	return context.WithValue(ctx, mdIncomingKey{}, md)
+}
+
+// FromIncomingContext returns the incoming metadata in ctx if it exists,
+// the returned MD must not be modified
+func FromIncomingContext(ctx context.Context) (MD, bool) {
+	md, ok := ctx.Value(mdIncomingKey{}).(MD)
+	return md, ok
+}
+
+// NewOutgoingContext creates a new context with outgoing md attached,
+// the client sends it along with the request
+func NewOutgoingContext(ctx context.Context, md MD) context.Context {
+	return context.WithValue(ctx, mdOutgoingKey{}, md)
+}
+
+// AppendToOutgoingContext returns a new context with the provided kv merged
+// with any existing metadata in the context
+func AppendToOutgoingContext(ctx context.Context, kv ...string) context.Context {
+	md, _ := FromOutgoingContext(ctx)
+	return NewOutgoingContext(ctx, Join(md, Pairs(kv...)))
+}
+
+// FromOutgoingContext returns the outgoing metadata in ctx if it exists,
+// the returned MD must not be modified
+func FromOutgoingContext(ctx context.Context) (MD, bool) {
+	md, ok := ctx.Value(mdOutgoingKey{}).(MD)
+	return md, ok
+}
@@ -0,0 +1,66 @@
+// Copyright 2022 <mzh.scnu@qq.com>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package metadata
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestPairs .
+func TestPairs(t *testing.T) {
+	md := Pairs("trace-id", "1", "tenant", "a")
+	assert.Equal(t, MD{"trace-id": "1", "tenant": "a"}, md)
+	assert.Equal(t, 2, md.Len())
+	assert.Equal(t, "a", md.Get("tenant"))
+	assert.Equal(t, "", md.Get("absent"))
+	assert.Panics(t, func() { Pairs("odd") })
+}
+
+// TestMD_Copy .
+func TestMD_Copy(t *testing.T) {
+	md := New(map[string]string{"k": "v"})
+	cp := md.Copy()
+	cp.Set("k", "w")
+	cp.Delete("absent")
+	assert.Equal(t, "v", md.Get("k"))
+	assert.Equal(t, "w", cp.Get("k"))
+}
+
+// TestJoin .
+func TestJoin(t *testing.T) {
+	md := Join(Pairs("a", "1", "b", "1"), nil, Pairs("b", "2"))
+	assert.Equal(t, MD{"a": "1", "b": "2"}, md)
+}
+
+// TestOutgoingContext .
+func TestOutgoingContext(t *testing.T) {
+	ctx := context.Background()
+	_, ok := FromOutgoingContext(ctx)
+	assert.Equal(t, false, ok)
+
+	ctx = NewOutgoingContext(ctx, Pairs("a", "1"))
+	ctx = AppendToOutgoingContext(ctx, "b", "2")
+	md, ok := FromOutgoingContext(ctx)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, MD{"a": "1", "b": "2"}, md)
+
+	// outgoing metadata is not incoming metadata
+	_, ok = FromIncomingContext(ctx)
+	assert.Equal(t, false, ok)
+}
+
+// TestIncomingContext .
+func TestIncomingContext(t *testing.T) {
+	ctx := NewIncomingContext(context.Background(), Pairs("a", "1"))
+	md, ok := FromIncomingContext(ctx)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, MD{"a": "1"}, md)
+
+	_, ok = FromOutgoingContext(ctx)
+	assert.Equal(t, false, ok)
+}
@@ -0,0 +1,67 @@
+// Copyright 2022 <mzh.scnu@qq.com>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tinyrpc
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zehuamama/tinyrpc/metadata"
+	pb "github.com/zehuamama/tinyrpc/test.data/message"
+)
+
+// MetadataService .
+type MetadataService struct{}
+
+// Tenant replies with the length of the tenant sent in the request metadata
+func (_ *MetadataService) Tenant(ctx context.Context, args *pb.ArithRequest, reply *pb.ArithResponse) error {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok || md.Get("tenant") == "" {
+		return errors.New("no tenant")
+	}
+	reply.C = float64(len(md.Get("tenant")))
+	return SetResponseMetadata(ctx, metadata.Pairs("tenant", md.Get("tenant"), "trace-id", md.Get("trace-id")))
+}
+
+// TestMetadata .
+func TestMetadata(t *testing.T) {
+	serverInterceptor := func(ctx context.Context, args interface{}, info *UnaryServerInfo,
+		handler UnaryHandler) (interface{}, error) {
+		reply, err := handler(ctx, args)
+		SetResponseMetadata(ctx, metadata.Pairs("served-by", "test"))
+		return reply, err
+	}
+	clientInterceptor := func(ctx context.Context, serviceMethod string, args interface{}, reply interface{},
+		invoker UnaryInvoker) error {
+		return invoker(metadata.AppendToOutgoingContext(ctx, "trace-id", "42"), serviceMethod, args, reply)
+	}
+	server := NewServer(WithServerInterceptors(serverInterceptor))
+	assert.Equal(t, nil, server.Register(new(MetadataService)))
+	client := serve(t, server, WithClientInterceptors(clientInterceptor))
+
+	var md metadata.MD
+	ctx := metadata.NewOutgoingContext(context.Background(), metadata.Pairs("tenant", "acme"))
+	ctx = NewResponseMetadataContext(ctx, &md)
+	reply := &pb.ArithResponse{}
+	err := client.CallContext(ctx, "MetadataService.Tenant", &pb.ArithRequest{}, reply)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, float64(4), reply.C)
+	assert.Equal(t, metadata.MD{"tenant": "acme", "trace-id": "42", "served-by": "test"}, md)
+
+	// the response metadata is also sent along with errors
+	md = nil
+	ctx = NewResponseMetadataContext(context.Background(), &md)
+	err = client.CallContext(ctx, "MetadataService.Tenant", &pb.ArithRequest{}, reply)
+	assert.Equal(t, "no tenant", err.Error())
+	assert.Equal(t, metadata.MD{"served-by": "test"}, md)
+}
+
+// TestSetResponseMetadata .
+func TestSetResponseMetadata(t *testing.T) {
+	err := SetResponseMetadata(context.Background(), metadata.Pairs("a", "1"))
+	assert.Equal(t, NotServerContextError, err)
+}
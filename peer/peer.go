@@ -0,0 +1,41 @@
+// Copyright 2022 <mzh.scnu@qq.com>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package peer
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+)
+
+// Peer is the client of a request as seen by the server
+type Peer struct {
+	Addr net.Addr             // the remote address, nil if unknown
+	TLS  *tls.ConnectionState // nil if the connection does not use TLS
+}
+
+// Certificate returns the verified certificate of the peer, it is nil
+// unless the server asked for client certificates and verified them
+func (p *Peer) Certificate() *x509.Certificate {
+	if p.TLS == nil || len(p.TLS.VerifiedChains) == 0 || len(p.TLS.VerifiedChains[0]) == 0 {
+		return nil
+	}
+	return p.TLS.VerifiedChains[0][0]
+}
+
+type peerKey struct{}
+
+// NewContext creates a new context with the peer attached,
+// it is used by the server for the client of a request
+func NewContext(ctx context.Context, p *Peer) context.Context {
+	return context.WithValue(ctx, peerKey{}, p)
+}
+
+// FromContext returns the peer in ctx if it exists
+func FromContext(ctx context.Context) (*Peer, bool) {
+	p, ok := ctx.Value(peerKey{}).(*Peer)
+	return p, ok
+}
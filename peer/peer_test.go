@@ -0,0 +1,40 @@
+// Copyright 2022 <mzh.scnu@qq.com>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package peer
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestFromContext .
+func TestFromContext(t *testing.T) {
+	_, ok := FromContext(context.Background())
+	assert.Equal(t, false, ok)
+
+	p := &Peer{Addr: &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 80}}
+	got, ok := FromContext(NewContext(context.Background(), p))
+	assert.Equal(t, true, ok)
+	assert.Equal(t, p, got)
+}
+
+// TestPeer_Certificate .
+func TestPeer_Certificate(t *testing.T) {
+	p := &Peer{}
+	assert.Equal(t, true, p.Certificate() == nil)
+
+	// certificates which have not been verified are not returned
+	cert := &x509.Certificate{}
+	p.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}
+	assert.Equal(t, true, p.Certificate() == nil)
+
+	p.TLS.VerifiedChains = [][]*x509.Certificate{{cert}}
+	assert.Equal(t, cert, p.Certificate())
+}
@@ -47,15 +47,21 @@ func (md *rpc) Generate(plugin *protogen.Plugin) error {
 		pkg := fmt.Sprintf("package %s", f.GoPackageName)
 		t.P(pkg)
 		t.P()
+		if hasStreams(f) {
+			t.P(`import (
+			"context"
+
+			"github.com/zehuamama/tinyrpc"
+			)`)
+			t.P()
+		}
 		for _, s := range f.Services {
 			comment := fmt.Sprintf("// %sServer You need to define a struct to implement these methods and then call them\n", s.Desc.Name())
 			serviceInterface := fmt.Sprintf("%stype %sServer interface{",
 				comment, s.Desc.Name())
 			t.P(serviceInterface)
 			for _, m := range s.Methods {
-				funcCode := fmt.Sprintf("%s(args *%s, reply *%s) error",
-					m.Desc.Name(), m.Input.Desc.Name(), m.Output.Desc.Name())
-				t.P(funcCode)
+				t.P(m.Desc.Name(), serverSignature(s, m))
 			}
 			t.P("}")
 
@@ -64,19 +70,121 @@ func (md *rpc) Generate(plugin *protogen.Plugin) error {
 			t.P(serviceCode)
 			t.P()
 			for _, m := range s.Methods {
-				funcCode := fmt.Sprintf(`%sfunc(this *%s) %s(args *%s,reply *%s)error{
+				funcCode := fmt.Sprintf(`%sfunc(this *%s) %s%s{
 					// define your service ...
 					return nil
 				}
 				`, getComments(m.Comments), s.Desc.Name(),
-					m.Desc.Name(), m.Input.Desc.Name(), m.Output.Desc.Name())
+					m.Desc.Name(), serverSignature(s, m))
 				t.P(funcCode)
 			}
+			if serviceHasStreams(s) {
+				generateServerStreams(t, s)
+			}
 		}
 	}
 	return nil
 }
 
+// serverSignature returns the parameters and results of the server method m
+func serverSignature(s *protogen.Service, m *protogen.Method) string {
+	switch {
+	case m.Desc.IsStreamingClient():
+		return fmt.Sprintf("(stream %s_%sServer) error", s.Desc.Name(), m.Desc.Name())
+	case m.Desc.IsStreamingServer():
+		return fmt.Sprintf("(args *%s, stream %s_%sServer) error",
+			m.Input.Desc.Name(), s.Desc.Name(), m.Desc.Name())
+	default:
+		return fmt.Sprintf("(args *%s, reply *%s) error", m.Input.Desc.Name(), m.Output.Desc.Name())
+	}
+}
+
+// generateServerStreams generates the typed stream interfaces of the stream methods
+// of s and a handler which adapts s to the reflection rules of tinyrpc.Server
+func generateServerStreams(t *protogen.GeneratedFile, s *protogen.Service) {
+	name := string(s.Desc.Name())
+	handler := unexport(name) + "Handler"
+	for _, m := range s.Methods {
+		if !isStream(m) {
+			continue
+		}
+		stream := fmt.Sprintf("%s_%sServer", name, m.Desc.Name())
+		impl := unexport(stream)
+		in, out := m.Input.Desc.Name(), m.Output.Desc.Name()
+		t.P(fmt.Sprintf("// %s is the server side stream of %s.%s", stream, name, m.Desc.Name()))
+		t.P("type ", stream, " interface {")
+		if m.Desc.IsStreamingClient() {
+			t.P("Recv() (*", in, ", error)")
+		}
+		if m.Desc.IsStreamingServer() {
+			t.P("Send(*", out, ") error")
+		} else {
+			t.P("SendAndClose(*", out, ") error")
+		}
+		t.P("Context() context.Context")
+		t.P("}")
+		t.P()
+		t.P("type ", impl, " struct {")
+		t.P("stream tinyrpc.ServerStream")
+		t.P("}")
+		t.P()
+		t.P(fmt.Sprintf(`func (x *%s) Context() context.Context {
+			return x.stream.Context()
+		}
+		`, impl))
+		if m.Desc.IsStreamingClient() {
+			t.P(fmt.Sprintf(`func (x *%s) Recv() (*%s, error) {
+				m := new(%s)
+				if err := x.stream.Recv(m); err != nil {
+					return nil, err
+				}
+				return m, nil
+			}
+			`, impl, in, in))
+		}
+		send := "Send"
+		if !m.Desc.IsStreamingServer() {
+			send = "SendAndClose"
+		}
+		t.P(fmt.Sprintf(`func (x *%s) %s(m *%s) error {
+			return x.stream.Send(m)
+		}
+		`, impl, send, out))
+	}
+
+	t.P(fmt.Sprintf(`// %s adapts %sServer to tinyrpc.Server
+		type %s struct {
+			srv %sServer
+		}
+		`, handler, name, handler, name))
+	for _, m := range s.Methods {
+		stream := unexport(fmt.Sprintf("%s_%sServer", name, m.Desc.Name()))
+		switch {
+		case m.Desc.IsStreamingClient():
+			t.P(fmt.Sprintf(`func (h *%s) %s(stream tinyrpc.ServerStream) error {
+				return h.srv.%s(&%s{stream})
+			}
+			`, handler, m.Desc.Name(), m.Desc.Name(), stream))
+		case m.Desc.IsStreamingServer():
+			t.P(fmt.Sprintf(`func (h *%s) %s(args *%s, stream tinyrpc.ServerStream) error {
+				return h.srv.%s(args, &%s{stream})
+			}
+			`, handler, m.Desc.Name(), m.Input.Desc.Name(), m.Desc.Name(), stream))
+		default:
+			t.P(fmt.Sprintf(`func (h *%s) %s(args *%s, reply *%s) error {
+				return h.srv.%s(args, reply)
+			}
+			`, handler, m.Desc.Name(), m.Input.Desc.Name(), m.Output.Desc.Name(), m.Desc.Name()))
+		}
+	}
+	t.P(fmt.Sprintf(`// Register%sServer registers srv as the %s service, services with
+		// stream methods must be registered with it rather than Server.Register
+		func Register%sServer(s *tinyrpc.Server, srv %sServer) error {
+			return s.RegisterName("%s", &%s{srv})
+		}
+		`, name, name, name, name, name, handler))
+}
+
 func generateCli(plugin *protogen.Plugin) error {
 	for _, f := range plugin.Files {
 		if len(f.Services) == 0 {
@@ -93,6 +201,14 @@ func generateCli(plugin *protogen.Plugin) error {
 		"github.com/zehuamama/tinyrpc"
 		"net"
 		)`
+		if hasStreams(f) {
+			importCode = `import (
+			"context"
+			"net"
+
+			"github.com/zehuamama/tinyrpc"
+			)`
+		}
 		t.P(importCode)
 		t.P()
 		for _, s := range f.Services {
@@ -111,6 +227,10 @@ func generateCli(plugin *protogen.Plugin) error {
 			t.P(newClientCode)
 			t.P()
 			for _, m := range s.Methods {
+				if isStream(m) {
+					generateClientStream(t, s, m)
+					continue
+				}
 				funcCode := fmt.Sprintf(`%sfunc(this *%sClient) %s(args *%s,reply *%s)error{
 					return this.client.Call("%s.%s", args, reply)
 				}
@@ -123,6 +243,127 @@ func generateCli(plugin *protogen.Plugin) error {
 	return nil
 }
 
+// generateClientStream generates the client method of the stream method m
+// and its typed stream interface
+func generateClientStream(t *protogen.GeneratedFile, s *protogen.Service, m *protogen.Method) {
+	name := string(s.Desc.Name())
+	stream := fmt.Sprintf("%s_%sClient", name, m.Desc.Name())
+	impl := unexport(stream)
+	in, out := m.Input.Desc.Name(), m.Output.Desc.Name()
+
+	if m.Desc.IsStreamingClient() {
+		t.P(fmt.Sprintf(`%sfunc(this *%sClient) %s(ctx context.Context) (%s, error) {
+			stream, err := this.client.NewBidiStream(ctx, "%s.%s")
+			if err != nil {
+				return nil, err
+			}
+			return &%s{stream}, nil
+		}
+		`, getComments(m.Comments), name, m.Desc.Name(), stream, name, m.Desc.Name(), impl))
+	} else {
+		t.P(fmt.Sprintf(`%sfunc(this *%sClient) %s(ctx context.Context, args *%s) (%s, error) {
+			stream, err := this.client.NewStream(ctx, "%s.%s", args)
+			if err != nil {
+				return nil, err
+			}
+			return &%s{stream}, nil
+		}
+		`, getComments(m.Comments), name, m.Desc.Name(), in, stream, name, m.Desc.Name(), impl))
+	}
+
+	t.P(fmt.Sprintf("// %s is the client side stream of %s.%s", stream, name, m.Desc.Name()))
+	t.P("type ", stream, " interface {")
+	if m.Desc.IsStreamingClient() {
+		t.P("Send(*", in, ") error")
+	}
+	switch {
+	case !m.Desc.IsStreamingServer():
+		t.P("CloseAndRecv() (*", out, ", error)")
+	case m.Desc.IsStreamingClient():
+		t.P("Recv() (*", out, ", error)")
+		t.P("CloseSend() error")
+	default:
+		t.P("Recv() (*", out, ", error)")
+	}
+	t.P("Context() context.Context")
+	t.P("Close() error")
+	t.P("}")
+	t.P()
+	t.P("type ", impl, " struct {")
+	t.P("stream *tinyrpc.ClientStream")
+	t.P("}")
+	t.P()
+	t.P(fmt.Sprintf(`func (x *%s) Context() context.Context {
+		return x.stream.Context()
+	}
+
+	func (x *%s) Close() error {
+		return x.stream.Close()
+	}
+	`, impl, impl))
+	if m.Desc.IsStreamingClient() {
+		t.P(fmt.Sprintf(`func (x *%s) Send(m *%s) error {
+			return x.stream.Send(m)
+		}
+		`, impl, in))
+	}
+	if m.Desc.IsStreamingServer() {
+		t.P(fmt.Sprintf(`func (x *%s) Recv() (*%s, error) {
+			m := new(%s)
+			if err := x.stream.Recv(m); err != nil {
+				return nil, err
+			}
+			return m, nil
+		}
+		`, impl, out, out))
+	} else {
+		t.P(fmt.Sprintf(`func (x *%s) CloseAndRecv() (*%s, error) {
+			m := new(%s)
+			if err := x.stream.CloseAndRecv(m); err != nil {
+				return nil, err
+			}
+			return m, nil
+		}
+		`, impl, out, out))
+	}
+	if m.Desc.IsStreamingClient() && m.Desc.IsStreamingServer() {
+		t.P(fmt.Sprintf(`func (x *%s) CloseSend() error {
+			return x.stream.CloseSend()
+		}
+		`, impl))
+	}
+}
+
+func isStream(m *protogen.Method) bool {
+	return m.Desc.IsStreamingClient() || m.Desc.IsStreamingServer()
+}
+
+func serviceHasStreams(s *protogen.Service) bool {
+	for _, m := range s.Methods {
+		if isStream(m) {
+			return true
+		}
+	}
+	return false
+}
+
+func hasStreams(f *protogen.File) bool {
+	for _, s := range f.Services {
+		if serviceHasStreams(s) {
+			return true
+		}
+	}
+	return false
+}
+
+// unexport lower cases the first letter of name
+func unexport(name string) string {
+	if name == "" {
+		return name
+	}
+	return strings.ToLower(name[:1]) + name[1:]
+}
+
 // getComments get comment details
 func getComments(comments protogen.CommentSet) string {
 	c := make([]string, 0)
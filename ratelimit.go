@@ -0,0 +1,241 @@
+// Copyright 2022 <mzh.scnu@qq.com>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tinyrpc
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/zehuamama/tinyrpc/codes"
+	"github.com/zehuamama/tinyrpc/peer"
+	"github.com/zehuamama/tinyrpc/status"
+)
+
+// ErrRateLimited is sent for requests rejected by a rate limit,
+// clients can tell it with errors.Is
+var ErrRateLimited = status.Error(codes.ResourceExhausted, "tinyrpc: rate limit exceeded")
+
+// RateLimit is a token bucket, Rate tokens are added per second up to
+// Burst tokens and every request takes one token
+type RateLimit struct {
+	Rate  float64
+	Burst int
+}
+
+// rateLimits are the rate limits of a server
+type rateLimits struct {
+	methods     map[string]RateLimit
+	remoteAddr  RateLimit
+	metadataKey string
+	metadata    RateLimit
+}
+
+// WithMethodRateLimit limits the rate of requests to serviceMethod,
+// like "ArithService.Add", over all clients
+func WithMethodRateLimit(serviceMethod string, limit RateLimit) Option {
+	return func(o *options) {
+		if o.rateLimits.methods == nil {
+			o.rateLimits.methods = make(map[string]RateLimit)
+		}
+		o.rateLimits.methods[serviceMethod] = limit
+	}
+}
+
+// WithRemoteAddrRateLimit limits the rate of requests per remote host,
+// the connections of a host share its limit. Connections served by
+// ServeCodec have no remote address and share a limit.
+func WithRemoteAddrRateLimit(limit RateLimit) Option {
+	return func(o *options) {
+		o.rateLimits.remoteAddr = limit
+	}
+}
+
+// WithMetadataRateLimit limits the rate of requests per value of the
+// metadata key, like a tenant ID, requests without the key share a limit
+func WithMetadataRateLimit(key string, limit RateLimit) Option {
+	return func(o *options) {
+		o.rateLimits.metadataKey = key
+		o.rateLimits.metadata = limit
+	}
+}
+
+// tokenBucket implements RateLimit
+type tokenBucket struct {
+	limit RateLimit
+
+	mutex  sync.Mutex // protects following
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(limit RateLimit, now time.Time) *tokenBucket {
+	return &tokenBucket{limit: limit, tokens: float64(limit.Burst), last: now}
+}
+
+// refill adds the tokens earned since the last call, the mutex must be held
+func (b *tokenBucket) refill(now time.Time) {
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens += elapsed.Seconds() * b.limit.Rate
+		if burst := float64(b.limit.Burst); b.tokens > burst {
+			b.tokens = burst
+		}
+	}
+	b.last = now
+}
+
+// allow takes a token if one is left
+func (b *tokenBucket) allow(now time.Time) bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.refill(now)
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// refund gives back a token taken by allow
+func (b *tokenBucket) refund() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.tokens++; b.tokens > float64(b.limit.Burst) {
+		b.tokens = float64(b.limit.Burst)
+	}
+}
+
+// full reports whether the bucket has refilled, it can be dropped then
+func (b *tokenBucket) full(now time.Time) bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.refill(now)
+	return b.tokens >= float64(b.limit.Burst)
+}
+
+// bucketSet is a token bucket per key, buckets which have refilled
+// are dropped from time to time so idle keys do not pile up
+type bucketSet struct {
+	limit RateLimit
+
+	mutex   sync.Mutex // protects following
+	buckets map[string]*tokenBucket
+	sweepAt int // the number of buckets which triggers the next sweep
+}
+
+// minSweep is the smallest number of buckets worth sweeping
+const minSweep = 1024
+
+func newBucketSet(limit RateLimit) *bucketSet {
+	return &bucketSet{
+		limit:   limit,
+		buckets: make(map[string]*tokenBucket),
+		sweepAt: minSweep,
+	}
+}
+
+func (s *bucketSet) allow(key string, now time.Time) bool {
+	return s.bucket(key, now).allow(now)
+}
+
+// bucket returns the bucket of key, creating it if needed
+func (s *bucketSet) bucket(key string, now time.Time) *tokenBucket {
+	s.mutex.Lock()
+	b, ok := s.buckets[key]
+	if !ok {
+		if len(s.buckets) >= s.sweepAt {
+			s.sweep(now)
+		}
+		b = newTokenBucket(s.limit, now)
+		s.buckets[key] = b
+	}
+	s.mutex.Unlock()
+	return b
+}
+
+// sweep drops the full buckets, the mutex must be held
+func (s *bucketSet) sweep(now time.Time) {
+	for key, b := range s.buckets {
+		if b.full(now) {
+			delete(s.buckets, key)
+		}
+	}
+	s.sweepAt = 2 * len(s.buckets)
+	if s.sweepAt < minSweep {
+		s.sweepAt = minSweep
+	}
+}
+
+// rateLimiter enforces the rate limits of a server
+type rateLimiter struct {
+	methods     map[string]*tokenBucket
+	remoteAddr  *bucketSet
+	metadataKey string
+	metadata    *bucketSet
+}
+
+func newRateLimiter(limits rateLimits) *rateLimiter {
+	now := time.Now()
+	l := &rateLimiter{}
+	for method, limit := range limits.methods {
+		if l.methods == nil {
+			l.methods = make(map[string]*tokenBucket)
+		}
+		l.methods[method] = newTokenBucket(limit, now)
+	}
+	if limits.remoteAddr != (RateLimit{}) {
+		l.remoteAddr = newBucketSet(limits.remoteAddr)
+	}
+	if limits.metadataKey != "" {
+		l.metadataKey = limits.metadataKey
+		l.metadata = newBucketSet(limits.metadata)
+	}
+	if l.methods == nil && l.remoteAddr == nil && l.metadata == nil {
+		return nil
+	}
+	return l
+}
+
+// allow reports whether req of connection c is within the rate limits,
+// a nil rateLimiter allows every request. A rejected request takes no
+// token, so it does not eat into the limits it was within.
+func (l *rateLimiter) allow(c *serverConn, req *request) bool {
+	if l == nil {
+		return true
+	}
+	now := time.Now()
+	var buf [3]*tokenBucket
+	buckets := buf[:0]
+	if l.metadata != nil {
+		buckets = append(buckets, l.metadata.bucket(req.h.Metadata[l.metadataKey], now))
+	}
+	if l.remoteAddr != nil {
+		buckets = append(buckets, l.remoteAddr.bucket(remoteHost(c.peer), now))
+	}
+	if b, ok := l.methods[req.h.Method]; ok {
+		buckets = append(buckets, b)
+	}
+	for i, b := range buckets {
+		if !b.allow(now) {
+			for _, taken := range buckets[:i] {
+				taken.refund()
+			}
+			return false
+		}
+	}
+	return true
+}
+
+// remoteHost returns the host of the address of p, or "" if it is unknown
+func remoteHost(p *peer.Peer) string {
+	if p == nil || p.Addr == nil {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(p.Addr.String())
+	if err != nil {
+		return p.Addr.String()
+	}
+	return host
+}
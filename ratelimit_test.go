@@ -0,0 +1,135 @@
+package tinyrpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zehuamama/tinyrpc/codes"
+	"github.com/zehuamama/tinyrpc/metadata"
+	"github.com/zehuamama/tinyrpc/status"
+	pb "github.com/zehuamama/tinyrpc/test.data/message"
+)
+
+// TestTokenBucket .
+func TestTokenBucket(t *testing.T) {
+	now := time.Now()
+	b := newTokenBucket(RateLimit{Rate: 10, Burst: 2}, now)
+	assert.Equal(t, true, b.allow(now))
+	assert.Equal(t, true, b.allow(now))
+	assert.Equal(t, false, b.allow(now))
+	assert.Equal(t, false, b.allow(now.Add(50*time.Millisecond)))
+	assert.Equal(t, true, b.allow(now.Add(100*time.Millisecond)))
+	assert.Equal(t, false, b.full(now.Add(100*time.Millisecond)))
+	// the tokens never exceed the burst
+	assert.Equal(t, true, b.full(now.Add(time.Hour)))
+	assert.Equal(t, true, b.allow(now.Add(time.Hour)))
+	assert.Equal(t, true, b.allow(now.Add(time.Hour)))
+	assert.Equal(t, false, b.allow(now.Add(time.Hour)))
+}
+
+// TestBucketSet_Sweep .
+func TestBucketSet_Sweep(t *testing.T) {
+	now := time.Now()
+	s := newBucketSet(RateLimit{Rate: 1, Burst: 1})
+	for i := 0; i < minSweep; i++ {
+		assert.Equal(t, true, s.allow(fmt.Sprint(i), now))
+	}
+	assert.Equal(t, false, s.allow("0", now))
+	// the buckets have refilled, they are dropped when the next key comes
+	assert.Equal(t, true, s.allow("new", now.Add(time.Second)))
+	assert.Equal(t, 1, len(s.buckets))
+	assert.Equal(t, minSweep, s.sweepAt)
+}
+
+// TestServer_MethodRateLimit .
+func TestServer_MethodRateLimit(t *testing.T) {
+	server := NewServer(WithMethodRateLimit("ArithService.Add", RateLimit{Rate: 0.001, Burst: 2}))
+	err := server.Register(new(pb.ArithService))
+	assert.Equal(t, nil, err)
+	client := serve(t, server)
+
+	args := &pb.ArithRequest{A: 20, B: 5}
+	for i := 0; i < 2; i++ {
+		err = client.Call("ArithService.Add", args, &pb.ArithResponse{})
+		assert.Equal(t, nil, err)
+	}
+	err = client.Call("ArithService.Add", args, &pb.ArithResponse{})
+	assert.Equal(t, true, errors.Is(err, ErrRateLimited))
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+
+	// other methods are not limited
+	err = client.Call("ArithService.Sub", args, &pb.ArithResponse{})
+	assert.Equal(t, nil, err)
+}
+
+// TestServer_RemoteAddrRateLimit .
+func TestServer_RemoteAddrRateLimit(t *testing.T) {
+	server := NewServer(WithRemoteAddrRateLimit(RateLimit{Rate: 0.001, Burst: 2}))
+	err := server.Register(new(pb.ArithService))
+	assert.Equal(t, nil, err)
+	client := serve(t, server)
+	other := serve(t, server)
+
+	args := &pb.ArithRequest{A: 20, B: 5}
+	err = client.Call("ArithService.Add", args, &pb.ArithResponse{})
+	assert.Equal(t, nil, err)
+	err = other.Call("ArithService.Add", args, &pb.ArithResponse{})
+	assert.Equal(t, nil, err)
+	// both connections come from the same host
+	err = client.Call("ArithService.Add", args, &pb.ArithResponse{})
+	assert.Equal(t, true, errors.Is(err, ErrRateLimited))
+}
+
+// TestServer_MetadataRateLimit .
+func TestServer_MetadataRateLimit(t *testing.T) {
+	server := NewServer(WithMetadataRateLimit("tenant", RateLimit{Rate: 0.001, Burst: 1}))
+	err := server.Register(new(pb.ArithService))
+	assert.Equal(t, nil, err)
+	client := serve(t, server)
+
+	args := &pb.ArithRequest{A: 20, B: 5}
+	acme := metadata.NewOutgoingContext(context.Background(), metadata.Pairs("tenant", "acme"))
+	other := metadata.NewOutgoingContext(context.Background(), metadata.Pairs("tenant", "other"))
+	err = client.CallContext(acme, "ArithService.Add", args, &pb.ArithResponse{})
+	assert.Equal(t, nil, err)
+	err = client.CallContext(acme, "ArithService.Add", args, &pb.ArithResponse{})
+	assert.Equal(t, true, errors.Is(err, ErrRateLimited))
+	err = client.CallContext(other, "ArithService.Add", args, &pb.ArithResponse{})
+	assert.Equal(t, nil, err)
+}
+
+// TestServer_RateLimitRefund .
+func TestServer_RateLimitRefund(t *testing.T) {
+	server := NewServer(
+		WithMetadataRateLimit("tenant", RateLimit{Rate: 0.001, Burst: 1}),
+		WithMethodRateLimit("ArithService.Add", RateLimit{Rate: 0.001, Burst: 2}),
+		WithRemoteAddrRateLimit(RateLimit{Rate: 0.001, Burst: 3}),
+	)
+	err := server.Register(new(pb.ArithService))
+	assert.Equal(t, nil, err)
+	client := serve(t, server)
+
+	args := &pb.ArithRequest{A: 20, B: 5}
+	acme := metadata.NewOutgoingContext(context.Background(), metadata.Pairs("tenant", "acme"))
+	other := metadata.NewOutgoingContext(context.Background(), metadata.Pairs("tenant", "other"))
+	err = client.CallContext(acme, "ArithService.Add", args, &pb.ArithResponse{})
+	assert.Equal(t, nil, err)
+	// a tenant over its limit does not use up the quota of the method
+	for i := 0; i < 3; i++ {
+		err = client.CallContext(acme, "ArithService.Add", args, &pb.ArithResponse{})
+		assert.Equal(t, true, errors.Is(err, ErrRateLimited))
+	}
+	err = client.CallContext(other, "ArithService.Add", args, &pb.ArithResponse{})
+	assert.Equal(t, nil, err)
+
+	// nor does a method over its limit use up the quota of a tenant
+	third := metadata.NewOutgoingContext(context.Background(), metadata.Pairs("tenant", "third"))
+	err = client.CallContext(third, "ArithService.Add", args, &pb.ArithResponse{})
+	assert.Equal(t, true, errors.Is(err, ErrRateLimited))
+	err = client.CallContext(third, "ArithService.Sub", args, &pb.ArithResponse{})
+	assert.Equal(t, nil, err)
+}
@@ -0,0 +1,67 @@
+// Copyright 2022 <mzh.scnu@qq.com>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package resolver
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"time"
+)
+
+type dns struct {
+	resolver *net.Resolver
+	interval time.Duration
+}
+
+// NewDNS returns a resolver which looks the targets up in the DNS every
+// interval. A target with a port, like "arith.example.com:8082", is resolved
+// to the A and AAAA records of its host. A target without one, like
+// "_arith._tcp.example.com", is resolved to the hosts and ports of its SRV
+// records. A non-positive interval means DefaultInterval, r is
+// net.DefaultResolver if it is nil.
+func NewDNS(interval time.Duration, r *net.Resolver) Resolver {
+	if r == nil {
+		r = net.DefaultResolver
+	}
+	return &dns{resolver: r, interval: interval}
+}
+
+func (d *dns) Watch(ctx context.Context, target string) (<-chan []string, error) {
+	return poll(ctx, d.interval, func(ctx context.Context) ([]string, error) {
+		if host, port, err := net.SplitHostPort(target); err == nil {
+			return d.lookupHost(ctx, host, port)
+		}
+		return d.lookupSRV(ctx, target)
+	})
+}
+
+func (d *dns) lookupHost(ctx context.Context, host, port string) ([]string, error) {
+	ips, err := d.resolver.LookupHost(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	addrs := make([]string, 0, len(ips))
+	for _, ip := range ips {
+		addrs = append(addrs, net.JoinHostPort(ip, port))
+	}
+	return addrs, nil
+}
+
+func (d *dns) lookupSRV(ctx context.Context, name string) ([]string, error) {
+	_, srvs, err := d.resolver.LookupSRV(ctx, "", "", name)
+	if err != nil {
+		return nil, err
+	}
+	var addrs []string
+	for _, srv := range srvs {
+		hosts, err := d.lookupHost(ctx, srv.Target, strconv.Itoa(int(srv.Port)))
+		if err != nil {
+			return nil, err
+		}
+		addrs = append(addrs, hosts...)
+	}
+	return addrs, nil
+}
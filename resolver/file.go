@@ -0,0 +1,65 @@
+// Copyright 2022 <mzh.scnu@qq.com>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package resolver
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+type file struct {
+	path     string
+	interval time.Duration
+}
+
+// NewFile returns a resolver which reads the addresses of the targets from
+// a JSON file, or a YAML file if its extension is .yaml or .yml, mapping
+// service names to address lists:
+//
+//	{"ArithService": ["10.0.0.1:8082", "10.0.0.2:8082"]}
+//
+// The file is checked for changes every interval, or every DefaultInterval
+// if interval is not positive.
+func NewFile(path string, interval time.Duration) Resolver {
+	return &file{path: path, interval: interval}
+}
+
+func (f *file) Watch(ctx context.Context, target string) (<-chan []string, error) {
+	return poll(ctx, f.interval, func(ctx context.Context) ([]string, error) {
+		services, err := f.read()
+		if err != nil {
+			return nil, err
+		}
+		addrs, ok := services[target]
+		if !ok {
+			return nil, fmt.Errorf("resolver: %s has no service %q", f.path, target)
+		}
+		return addrs, nil
+	})
+}
+
+func (f *file) read() (map[string][]string, error) {
+	data, err := os.ReadFile(f.path)
+	if err != nil {
+		return nil, err
+	}
+	var services map[string][]string
+	switch filepath.Ext(f.path) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &services)
+	default:
+		err = json.Unmarshal(data, &services)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("resolver: parsing %s: %w", f.path, err)
+	}
+	return services, nil
+}
@@ -0,0 +1,116 @@
+// Copyright 2022 <mzh.scnu@qq.com>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package resolver finds the addresses a logical service can be reached at
+package resolver
+
+import (
+	"context"
+	"log"
+	"sort"
+	"time"
+)
+
+// DefaultInterval is how often targets are looked up again when a
+// resolver is given a non-positive interval
+const DefaultInterval = 30 * time.Second
+
+// Resolver finds the endpoints of logical service names
+type Resolver interface {
+	// Watch returns a channel which receives the addresses of target whenever
+	// they change, the current ones can be received right away. The channel
+	// is closed once ctx is done. Watch fails if the target can't be resolved.
+	Watch(ctx context.Context, target string) (<-chan []string, error)
+}
+
+// lookupFunc returns the current addresses of a target
+type lookupFunc func(ctx context.Context) ([]string, error)
+
+// poll calls lookup every interval and sends the addresses whenever they change
+func poll(ctx context.Context, interval time.Duration, lookup lookupFunc) (<-chan []string, error) {
+	if interval <= 0 {
+		interval = DefaultInterval
+	}
+	addrs, err := lookup(ctx)
+	if err != nil {
+		return nil, err
+	}
+	addrs = normalize(addrs)
+	updates := make(chan []string, 1)
+	updates <- addrs
+	go func() {
+		defer close(updates)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			latest, err := lookup(ctx)
+			if err != nil {
+				if ctx.Err() == nil {
+					log.Println("tinyrpc: resolver:", err)
+				}
+				continue
+			}
+			latest = normalize(latest)
+			if equal(addrs, latest) {
+				continue
+			}
+			addrs = latest
+			// only the latest addresses matter to a slow receiver
+			select {
+			case <-updates:
+			default:
+			}
+			updates <- addrs
+		}
+	}()
+	return updates, nil
+}
+
+// normalize sorts the addresses and removes duplicates
+func normalize(addrs []string) []string {
+	out := make([]string, 0, len(addrs))
+	seen := make(map[string]bool, len(addrs))
+	for _, addr := range addrs {
+		if !seen[addr] {
+			seen[addr] = true
+			out = append(out, addr)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+type static []string
+
+// NewStatic returns a resolver which resolves every target to addrs
+func NewStatic(addrs ...string) Resolver {
+	return static(normalize(addrs))
+}
+
+func (s static) Watch(ctx context.Context, target string) (<-chan []string, error) {
+	updates := make(chan []string, 1)
+	updates <- []string(s)
+	go func() {
+		<-ctx.Done()
+		close(updates)
+	}()
+	return updates, nil
+}
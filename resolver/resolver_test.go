@@ -0,0 +1,255 @@
+package resolver
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func next(t *testing.T, updates <-chan []string) []string {
+	select {
+	case addrs := <-updates:
+		return addrs
+	case <-time.After(2 * time.Second):
+		t.Fatal("no update")
+		return nil
+	}
+}
+
+// TestStatic .
+func TestStatic(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	updates, err := NewStatic("b:1", "a:1", "b:1").Watch(ctx, "ArithService")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []string{"a:1", "b:1"}, next(t, updates))
+
+	cancel()
+	_, ok := <-updates
+	assert.Equal(t, false, ok)
+}
+
+// TestFile .
+func TestFile(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		content []string
+	}{
+		{"hosts.json", []string{
+			`{"ArithService": ["10.0.0.2:8082", "10.0.0.1:8082"]}`,
+			`{"ArithService": ["10.0.0.3:8082"]}`,
+		}},
+		{"hosts.yaml", []string{
+			"ArithService:\n  - 10.0.0.2:8082\n  - 10.0.0.1:8082\n",
+			"ArithService:\n  - 10.0.0.3:8082\n",
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), tc.name)
+			assert.Equal(t, nil, os.WriteFile(path, []byte(tc.content[0]), 0600))
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			r := NewFile(path, 10*time.Millisecond)
+			updates, err := r.Watch(ctx, "ArithService")
+			assert.Equal(t, nil, err)
+			assert.Equal(t, []string{"10.0.0.1:8082", "10.0.0.2:8082"}, next(t, updates))
+
+			assert.Equal(t, nil, os.WriteFile(path, []byte(tc.content[1]), 0600))
+			assert.Equal(t, []string{"10.0.0.3:8082"}, next(t, updates))
+
+			_, err = r.Watch(ctx, "EchoService")
+			assert.NotEqual(t, nil, err)
+		})
+	}
+
+	_, err := NewFile(filepath.Join(t.TempDir(), "missing.json"), time.Second).
+		Watch(context.Background(), "ArithService")
+	assert.NotEqual(t, nil, err)
+
+	// a zero interval falls back to DefaultInterval
+	path := filepath.Join(t.TempDir(), "hosts.json")
+	assert.Equal(t, nil, os.WriteFile(path, []byte(`{"ArithService": ["10.0.0.1:8082"]}`), 0600))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	updates, err := NewFile(path, 0).Watch(ctx, "ArithService")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []string{"10.0.0.1:8082"}, next(t, updates))
+}
+
+const (
+	typeA    = 1
+	typeAAAA = 28
+	typeSRV  = 33
+)
+
+type srvRecord struct {
+	port   uint16
+	target string
+}
+
+// fakeDNS answers A and SRV queries from its records
+type fakeDNS struct {
+	conn net.PacketConn
+
+	mutex sync.Mutex
+	a     map[string][]net.IP
+	srv   map[string][]srvRecord
+}
+
+func newFakeDNS(t *testing.T) *fakeDNS {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := &fakeDNS{conn: conn, a: map[string][]net.IP{}, srv: map[string][]srvRecord{}}
+	t.Cleanup(func() { conn.Close() })
+	go d.serve()
+	return d
+}
+
+// resolver returns a resolver which sends every query to d
+func (d *fakeDNS) resolver() *net.Resolver {
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, "udp", d.conn.LocalAddr().String())
+		},
+	}
+}
+
+func (d *fakeDNS) serve() {
+	buf := make([]byte, 512)
+	for {
+		n, addr, err := d.conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		if resp := d.answer(buf[:n]); resp != nil {
+			d.conn.WriteTo(resp, addr)
+		}
+	}
+}
+
+func (d *fakeDNS) answer(query []byte) []byte {
+	if len(query) < 12 {
+		return nil
+	}
+	// the question name is a sequence of labels ending with an empty one
+	i := 12
+	var labels []string
+	for i < len(query) && query[i] != 0 {
+		l := int(query[i])
+		if i+1+l > len(query) {
+			return nil
+		}
+		labels = append(labels, string(query[i+1:i+1+l]))
+		i += 1 + l
+	}
+	if i+5 > len(query) {
+		return nil
+	}
+	name := strings.ToLower(strings.Join(labels, "."))
+	qtype := binary.BigEndian.Uint16(query[i+1:])
+	question := query[12 : i+5]
+
+	var answers [][]byte
+	found := false
+	d.mutex.Lock()
+	if ips, ok := d.a[name]; ok {
+		found = true
+		for _, ip := range ips {
+			if qtype == typeA {
+				answers = append(answers, record(typeA, ip.To4()))
+			}
+		}
+	}
+	if srvs, ok := d.srv[name]; ok {
+		found = true
+		for _, srv := range srvs {
+			if qtype == typeSRV {
+				data := make([]byte, 6)
+				binary.BigEndian.PutUint16(data[4:], srv.port)
+				answers = append(answers, record(typeSRV, append(data, encodeName(srv.target)...)))
+			}
+		}
+	}
+	d.mutex.Unlock()
+
+	resp := make([]byte, 12, 512)
+	copy(resp, query[:2])
+	flags := uint16(0x8180) // response, recursion desired and available
+	if !found {
+		flags |= 3 // name error
+	}
+	binary.BigEndian.PutUint16(resp[2:], flags)
+	binary.BigEndian.PutUint16(resp[4:], 1)
+	binary.BigEndian.PutUint16(resp[6:], uint16(len(answers)))
+	resp = append(resp, question...)
+	for _, answer := range answers {
+		resp = append(resp, answer...)
+	}
+	return resp
+}
+
+// record encodes an answer for the name of the question
+func record(rtype uint16, data []byte) []byte {
+	rr := make([]byte, 12)
+	rr[0], rr[1] = 0xc0, 12 // pointer to the question name
+	binary.BigEndian.PutUint16(rr[2:], rtype)
+	binary.BigEndian.PutUint16(rr[4:], 1) // IN
+	binary.BigEndian.PutUint32(rr[6:], 60)
+	binary.BigEndian.PutUint16(rr[10:], uint16(len(data)))
+	return append(rr, data...)
+}
+
+func encodeName(name string) []byte {
+	var b []byte
+	for _, label := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+		b = append(b, byte(len(label)))
+		b = append(b, label...)
+	}
+	return append(b, 0)
+}
+
+// TestDNS .
+func TestDNS(t *testing.T) {
+	d := newFakeDNS(t)
+	d.mutex.Lock()
+	d.a["arith.tinyrpc.test"] = []net.IP{net.ParseIP("10.0.0.2"), net.ParseIP("10.0.0.1")}
+	d.a["node1.tinyrpc.test"] = []net.IP{net.ParseIP("10.0.1.1")}
+	d.a["node2.tinyrpc.test"] = []net.IP{net.ParseIP("10.0.1.2")}
+	d.srv["_arith._tcp.tinyrpc.test"] = []srvRecord{
+		{8082, "node1.tinyrpc.test."},
+		{8083, "node2.tinyrpc.test."},
+	}
+	d.mutex.Unlock()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	r := NewDNS(10*time.Millisecond, d.resolver())
+
+	updates, err := r.Watch(ctx, "arith.tinyrpc.test.:8082")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []string{"10.0.0.1:8082", "10.0.0.2:8082"}, next(t, updates))
+
+	d.mutex.Lock()
+	d.a["arith.tinyrpc.test"] = []net.IP{net.ParseIP("10.0.0.3")}
+	d.mutex.Unlock()
+	assert.Equal(t, []string{"10.0.0.3:8082"}, next(t, updates))
+
+	updates, err = r.Watch(ctx, "_arith._tcp.tinyrpc.test.")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []string{"10.0.1.1:8082", "10.0.1.2:8083"}, next(t, updates))
+
+	_, err = r.Watch(ctx, "missing.tinyrpc.test.:8082")
+	assert.NotEqual(t, nil, err)
+}
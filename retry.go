@@ -0,0 +1,136 @@
+// Copyright 2022 <mzh.scnu@qq.com>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tinyrpc
+
+import (
+	"context"
+	"errors"
+	"net/rpc"
+	"time"
+
+	"github.com/zehuamama/tinyrpc/status"
+)
+
+const (
+	defaultRetryBackoffBase = 50 * time.Millisecond
+	defaultRetryBackoffMax  = time.Second
+)
+
+// RetryPolicy configures how a ClientConn re-sends failed calls.
+//
+// A call whose request provably never reached a handler is always retried,
+// e.g. when no connection is available. Otherwise it is retried only if its
+// method has been declared idempotent with WithIdempotentMethods and Retryable
+// accepts its error. Errors returned by a service method are application
+// errors, they are not retried by default.
+type RetryPolicy struct {
+	// MaxAttempts is the number of attempts including the first one,
+	// calls are not retried when it is less than 2
+	MaxAttempts int
+	// InitialBackoff is the delay before the first retry, it doubles
+	// with every retry up to MaxBackoff, both are randomized by ±20%.
+	// They default to 50ms and 1s.
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	// Retryable classifies the errors, it defaults to IsTransportError
+	Retryable func(err error) bool
+}
+
+// WithRetryPolicy sets the retry policy of a ClientConn
+func WithRetryPolicy(policy RetryPolicy) Option {
+	return func(o *options) {
+		o.retryPolicy = policy
+	}
+}
+
+// WithIdempotentMethods declares service methods, like "ArithService.Add",
+// which are safe to execute more than once
+func WithIdempotentMethods(serviceMethods ...string) Option {
+	return func(o *options) {
+		if o.idempotent == nil {
+			o.idempotent = make(map[string]bool)
+		}
+		for _, serviceMethod := range serviceMethods {
+			o.idempotent[serviceMethod] = true
+		}
+	}
+}
+
+// IsTransportError reports whether err is caused by the connection or the
+// protocol rather than sent by the server, which are rpc.ServerError and
+// status errors. Errors of the context of a call are neither.
+func IsTransportError(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	if _, ok := status.FromError(err); ok {
+		return false
+	}
+	var serverError rpc.ServerError
+	return !errors.As(err, &serverError)
+}
+
+// notSent reports whether err proves the request was never handled,
+// so it may be re-sent even if its method is not idempotent
+func notSent(err error) bool {
+	return err == ErrUnavailable || err == rpc.ErrShutdown || err == ErrCircuitOpen ||
+		errors.Is(err, errServerClosedStatus)
+}
+
+// retrier decides whether and when a failed call is retried
+type retrier struct {
+	policy     RetryPolicy
+	backoff    backoff
+	idempotent map[string]bool
+}
+
+func newRetrier(policy RetryPolicy, idempotent map[string]bool) *retrier {
+	if policy.MaxAttempts < 2 {
+		return nil
+	}
+	r := &retrier{
+		policy:     policy,
+		backoff:    backoff{base: policy.InitialBackoff, max: policy.MaxBackoff},
+		idempotent: idempotent,
+	}
+	if r.backoff.base <= 0 {
+		r.backoff.base = defaultRetryBackoffBase
+	}
+	if r.backoff.max < r.backoff.base {
+		r.backoff.max = defaultRetryBackoffMax
+		if r.backoff.max < r.backoff.base {
+			r.backoff.max = r.backoff.base
+		}
+	}
+	if r.policy.Retryable == nil {
+		r.policy.Retryable = IsTransportError
+	}
+	return r
+}
+
+// retry reports whether the attempt-th attempt, counted from 0,
+// of a call which failed with err may be followed by another one
+func (r *retrier) retry(serviceMethod string, attempt int, err error) bool {
+	if attempt+1 >= r.policy.MaxAttempts {
+		return false
+	}
+	if notSent(err) {
+		return true
+	}
+	return r.idempotent[serviceMethod] && r.policy.Retryable(err)
+}
+
+// wait sleeps before the retry following the attempt-th attempt,
+// it returns the error of ctx if ctx is done first
+func (r *retrier) wait(ctx context.Context, attempt int) error {
+	timer := time.NewTimer(r.backoff.delay(attempt))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
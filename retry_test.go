@@ -0,0 +1,129 @@
+package tinyrpc
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/rpc"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	pb "github.com/zehuamama/tinyrpc/test.data/message"
+)
+
+type FlakyService struct {
+	calls int32
+}
+
+func (s *FlakyService) Get(args *pb.ArithRequest, reply *pb.ArithResponse) error {
+	if atomic.AddInt32(&s.calls, 1) < 3 {
+		return errors.New("try again")
+	}
+	reply.C = args.A
+	return nil
+}
+
+func (s *FlakyService) Put(args *pb.ArithRequest, reply *pb.ArithResponse) error {
+	atomic.AddInt32(&s.calls, 1)
+	return errors.New("try again")
+}
+
+// TestIsTransportError .
+func TestIsTransportError(t *testing.T) {
+	cases := []struct {
+		err    error
+		expect bool
+	}{
+		{nil, false},
+		{io.ErrUnexpectedEOF, true},
+		{ErrUnavailable, true},
+		{rpc.ServerError("failed"), false},
+		{context.Canceled, false},
+		{context.DeadlineExceeded, false},
+	}
+	for _, c := range cases {
+		assert.Equal(t, c.expect, IsTransportError(c.err), c.err)
+	}
+}
+
+// TestRetryPolicy .
+func TestRetryPolicy(t *testing.T) {
+	server, addr := listenArith(t, "127.0.0.1:0")
+	defer server.Close()
+	flaky := new(FlakyService)
+	err := server.Register(flaky)
+	assert.Equal(t, nil, err)
+
+	retryAll := RetryPolicy{
+		MaxAttempts:    5,
+		InitialBackoff: time.Millisecond,
+		Retryable:      func(err error) bool { return true },
+	}
+
+	// application errors are not retried by default
+	cc, err := Dial("tcp", addr, WithRetryPolicy(RetryPolicy{MaxAttempts: 5}),
+		WithIdempotentMethods("FlakyService.Get"))
+	assert.Equal(t, nil, err)
+	defer cc.Close()
+	resp := &pb.ArithResponse{}
+	err = cc.Call("FlakyService.Get", &pb.ArithRequest{A: 7}, resp)
+	assert.Equal(t, rpc.ServerError("try again"), err)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&flaky.calls))
+
+	// idempotent methods are retried
+	atomic.StoreInt32(&flaky.calls, 0)
+	cc, err = Dial("tcp", addr, WithRetryPolicy(retryAll), WithIdempotentMethods("FlakyService.Get"))
+	assert.Equal(t, nil, err)
+	defer cc.Close()
+	err = cc.Call("FlakyService.Get", &pb.ArithRequest{A: 7}, resp)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, float64(7), resp.C)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&flaky.calls))
+
+	// others are not
+	atomic.StoreInt32(&flaky.calls, 0)
+	err = cc.Call("FlakyService.Put", &pb.ArithRequest{A: 7}, resp)
+	assert.Equal(t, rpc.ServerError("try again"), err)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&flaky.calls))
+
+	// up to MaxAttempts
+	atomic.StoreInt32(&flaky.calls, 0)
+	cc, err = Dial("tcp", addr, WithRetryPolicy(retryAll), WithIdempotentMethods("FlakyService.Put"))
+	assert.Equal(t, nil, err)
+	defer cc.Close()
+	call := <-cc.AsyncCall("FlakyService.Put", &pb.ArithRequest{A: 7}, resp)
+	assert.Equal(t, rpc.ServerError("try again"), call.Error)
+	assert.Equal(t, int32(5), atomic.LoadInt32(&flaky.calls))
+}
+
+// TestRetryPolicy_Reconnect .
+func TestRetryPolicy_Reconnect(t *testing.T) {
+	server, addr := listenArith(t, "127.0.0.1:0")
+
+	cc, err := Dial("tcp", addr, WithReconnectBackoff(10*time.Millisecond, 20*time.Millisecond),
+		WithRetryPolicy(RetryPolicy{MaxAttempts: 50, InitialBackoff: 10 * time.Millisecond}))
+	assert.Equal(t, nil, err)
+	defer cc.Close()
+
+	server.Close()
+	assert.Eventually(t, func() bool {
+		_, err := cc.pick(context.Background(), "ArithService.Add")
+		return err == ErrUnavailable
+	}, time.Second, time.Millisecond)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		time.Sleep(100 * time.Millisecond)
+		server, _ = listenArith(t, addr)
+	}()
+
+	// calls which were never sent are retried once the connection is back
+	resp := &pb.ArithResponse{}
+	err = cc.Call("ArithService.Add", &pb.ArithRequest{A: 20, B: 5}, resp)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, float64(25), resp.C)
+	<-done
+	server.Close()
+}
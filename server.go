@@ -5,18 +5,92 @@
 package tinyrpc
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
 	"log"
 	"net"
-	"net/rpc"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/zehuamama/tinyrpc/codec"
+	"github.com/zehuamama/tinyrpc/codes"
+	"github.com/zehuamama/tinyrpc/header"
+	"github.com/zehuamama/tinyrpc/health"
+	"github.com/zehuamama/tinyrpc/metadata"
+	"github.com/zehuamama/tinyrpc/peer"
 	"github.com/zehuamama/tinyrpc/serializer"
+	"github.com/zehuamama/tinyrpc/status"
 )
 
-// Server rpc server based on net/rpc implementation
+// ErrServerClosed is returned by Serve after a call to Shutdown or Close,
+// it is also the message of requests that arrive while the server shuts down
+var ErrServerClosed = errors.New("tinyrpc: Server closed")
+
+// errServerClosedStatus is sent for requests that arrive while the server
+// shuts down, they have not been handled
+var errServerClosedStatus = status.Error(codes.Unavailable, ErrServerClosed.Error())
+
+const (
+	// shutdownPollInterval is how often Shutdown checks for idle connections
+	shutdownPollInterval = 10 * time.Millisecond
+	// drainGrace is how long a draining connection is kept open after GOAWAY,
+	// so requests the client sent before it got GOAWAY are read and answered
+	// with errServerClosedStatus rather than lost. Clients which close the
+	// connection once they got GOAWAY, like Client, don't wait that long.
+	drainGrace = time.Second
+)
+
+// Server rpc server, service methods are registered and dispatched
+// the same way as net/rpc, besides they may take a context.Context as
+// their first argument
 type Server struct {
-	*rpc.Server
-	serializer.Serializer
+	serviceMap   sync.Map // map[string]*service
+	serializer   serializer.Serializer
+	limits       codec.Limits
+	streamWindow int
+	interceptor  UnaryServerInterceptor
+
+	handlers        semaphore // bounds the requests being handled
+	maxConnInFlight int
+	maxConnQueued   int
+	queueOnLimit    bool
+	rateLimiter     *rateLimiter
+	tlsConfig       *tls.Config
+	verifier        Verifier
+	health          *health.Server
+
+	keepaliveInterval time.Duration
+	keepaliveTimeout  time.Duration
+	idleTimeout       time.Duration
+
+	mu         sync.Mutex // protects following
+	listeners  map[net.Listener]struct{}
+	conns      map[*serverConn]struct{}
+	inShutdown bool
+	// healthReplaced is set once the application has registered
+	// its own Health service in place of the built-in one
+	healthReplaced bool
+}
+
+// serverConn is a connection being served
+type serverConn struct {
+	cc       codec.ServerCodec
+	peer     *peer.Peer // nil if unknown
+	sending  sync.Mutex // serializes responses
+	inFlight semaphore  // bounds the requests of the connection
+	queue    semaphore  // bounds the requests waiting for a slot
+	live     *liveness  // when the client was last heard from
+
+	mu        sync.Mutex          // protects following
+	active    int                 // requests read but not answered yet
+	idleSince time.Time           // when active dropped to zero
+	draining  bool                // the server is shutting down
+	graceOver bool                // drainGrace has passed since GOAWAY
+	calls     map[uint64]*request // the calls being handled
 }
 
 // NewServer Create a new rpc server
@@ -28,27 +102,683 @@ func NewServer(opts ...Option) *Server {
 		option(&options)
 	}
 
-	return &Server{&rpc.Server{}, options.serializer}
+	s := &Server{
+		serializer:   options.serializer,
+		limits:       options.limits,
+		streamWindow: streamWindow(options.streamWindow),
+		interceptor:  chainUnaryServerInterceptors(options.serverInterceptors),
+
+		handlers:        newSemaphore(options.maxHandlers),
+		maxConnInFlight: options.maxConnInFlight,
+		maxConnQueued:   maxConnQueued(options.maxConnQueued),
+		queueOnLimit:    options.queueOnLimit,
+		rateLimiter:     newRateLimiter(options.rateLimits),
+		tlsConfig:       options.tlsConfig,
+		verifier:        options.verifier,
+
+		keepaliveInterval: options.keepaliveInterval,
+		keepaliveTimeout:  options.keepaliveTimeout,
+		idleTimeout:       options.idleTimeout,
+		health:            health.NewServer(),
+	}
+	if err := s.RegisterName(health.ServiceName, s.health); err != nil {
+		log.Panic(err)
+	}
+	return s
 }
 
 // Register register rpc function
 func (s *Server) Register(rcvr interface{}) error {
-	return s.Server.Register(rcvr)
+	return s.register(rcvr, "", false)
 }
 
 // RegisterName register the rpc function with the specified name
 func (s *Server) RegisterName(name string, rcvr interface{}) error {
-	return s.Server.RegisterName(name, rcvr)
+	return s.register(rcvr, name, true)
+}
+
+func (s *Server) register(rcvr interface{}, name string, useName bool) error {
+	svc, err := newService(rcvr, name, useName)
+	if err != nil {
+		return err
+	}
+	if _, dup := s.serviceMap.LoadOrStore(svc.name, svc); dup && !s.replaceHealth(svc) {
+		return errors.New("rpc: service already defined: " + svc.name)
+	}
+	if svc.name != health.ServiceName {
+		s.health.SetServingStatus(svc.name, health.HealthCheckResponse_SERVING)
+	}
+	return nil
+}
+
+// replaceHealth lets svc take the place of the built-in Health service, it
+// reports false if svc is not named Health or the built-in one is gone already
+func (s *Server) replaceHealth(svc *service) bool {
+	if svc.name != health.ServiceName {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.healthReplaced {
+		return false
+	}
+	s.healthReplaced = true
+	s.serviceMap.Store(svc.name, svc)
+	return true
 }
 
-// Serve start service
-func (s *Server) Serve(lis net.Listener) {
+// Serve start service, it always returns a non-nil error.
+// After Shutdown or Close, the returned error is ErrServerClosed
+func (s *Server) Serve(lis net.Listener) error {
+	if !s.trackListener(lis, true) {
+		return ErrServerClosed
+	}
+	defer s.trackListener(lis, false)
+
 	log.Printf("tinyrpc started on: %s", lis.Addr().String())
+	var tempDelay time.Duration // how long to sleep on accept failure
 	for {
 		conn, err := lis.Accept()
 		if err != nil {
+			if s.shuttingDown() {
+				return ErrServerClosed
+			}
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				if tempDelay == 0 {
+					tempDelay = 5 * time.Millisecond
+				} else {
+					tempDelay *= 2
+				}
+				if max := 1 * time.Second; tempDelay > max {
+					tempDelay = max
+				}
+				log.Printf("tinyrpc: accept error: %v; retrying in %v", err, tempDelay)
+				time.Sleep(tempDelay)
+				continue
+			}
+			return err
+		}
+		tempDelay = 0
+		go s.ServeConn(conn)
+	}
+}
+
+// Shutdown gracefully shuts down the server: it closes all listeners,
+// tells the clients to stop sending requests, waits for the requests
+// in flight to be answered and then closes the connections.
+// If ctx is done before, Shutdown returns ctx.Err() and the remaining
+// connections are left open, Close can be used to drop them.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.health.Shutdown()
+	s.mu.Lock()
+	s.inShutdown = true
+	err := s.closeListenersLocked()
+	conns := make([]*serverConn, 0, len(s.conns))
+	for c := range s.conns {
+		conns = append(conns, c)
+	}
+	s.mu.Unlock()
+
+	for _, c := range conns {
+		c.drain()
+	}
+
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+	for {
+		s.mu.Lock()
+		idle := len(s.conns) == 0
+		s.mu.Unlock()
+		if idle {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Close immediately closes all listeners and connections,
+// the requests in flight are not answered
+func (s *Server) Close() error {
+	s.health.Shutdown()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.inShutdown = true
+	err := s.closeListenersLocked()
+	for c := range s.conns {
+		c.cc.Close()
+	}
+	return err
+}
+
+func (s *Server) closeListenersLocked() error {
+	var err error
+	for lis := range s.listeners {
+		if cerr := lis.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	s.listeners = nil
+	return err
+}
+
+func (s *Server) shuttingDown() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.inShutdown
+}
+
+// trackListener adds or removes a listener, it reports false
+// when the server is already shutting down
+func (s *Server) trackListener(lis net.Listener, add bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if add {
+		if s.inShutdown {
+			return false
+		}
+		if s.listeners == nil {
+			s.listeners = make(map[net.Listener]struct{})
+		}
+		s.listeners[lis] = struct{}{}
+	} else {
+		delete(s.listeners, lis)
+	}
+	return true
+}
+
+// trackConn adds or removes a connection, it reports false
+// when the server is already shutting down
+func (s *Server) trackConn(c *serverConn, add bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if add {
+		if s.inShutdown {
+			return false
+		}
+		if s.conns == nil {
+			s.conns = make(map[*serverConn]struct{})
+		}
+		s.conns[c] = struct{}{}
+	} else {
+		delete(s.conns, c)
+	}
+	return true
+}
+
+// ServeConn serves a single connection until the client hangs up,
+// the peer of a net.Conn is available to the handlers
+func (s *Server) ServeConn(conn io.ReadWriteCloser) {
+	var p *peer.Peer
+	if nc, ok := conn.(net.Conn); ok {
+		p = &peer.Peer{Addr: nc.RemoteAddr()}
+	}
+	if tc, ok := conn.(*tls.Conn); ok {
+		state, err := handshake(tc)
+		if err != nil {
+			log.Printf("tinyrpc: TLS handshake error from %s: %v", tc.RemoteAddr(), err)
+			tc.Close()
+			return
+		}
+		p.TLS = state
+	}
+	s.serveCodec(codec.NewServer(conn, s.serializer, s.limits), p)
+}
+
+// ServeCodec serves requests read from the codec until it fails,
+// each request is handled by its own goroutine
+func (s *Server) ServeCodec(cc codec.ServerCodec) {
+	s.serveCodec(cc, nil)
+}
+
+func (s *Server) serveCodec(cc codec.ServerCodec, p *peer.Peer) {
+	c := &serverConn{
+		cc:        cc,
+		peer:      p,
+		inFlight:  newSemaphore(s.maxConnInFlight),
+		queue:     newSemaphore(s.maxConnQueued),
+		live:      newLiveness(),
+		idleSince: time.Now(),
+	}
+	if !s.trackConn(c, true) {
+		cc.Close()
+		return
+	}
+	defer s.trackConn(c, false)
+
+	// ctx is canceled once the connection is gone, so handlers of
+	// requests nobody waits for anymore can stop early
+	ctx := context.Background()
+	if p != nil {
+		ctx = peer.NewContext(ctx, p)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	if s.keepaliveInterval > 0 {
+		go s.keepalive(ctx, c)
+	}
+	if s.idleTimeout > 0 {
+		go s.reapIdle(ctx, c)
+	}
+	wg := new(sync.WaitGroup)
+	for {
+		req, keepReading, err := s.readRequest(ctx, cc)
+		if req != nil {
+			c.live.touch()
+		}
+		if err != nil {
+			if !keepReading {
+				var tooLarge *codec.TooLargeError
+				if err == codec.InvalidMagicError || err == codec.UnsupportedVersionError ||
+					errors.As(err, &tooLarge) {
+					log.Println("tinyrpc: closing connection:", err)
+				}
+				// the header was read but its body was not, tell the
+				// client why before the connection is closed
+				if req != nil {
+					if errors.As(err, &tooLarge) {
+						err = status.Error(codes.ResourceExhausted, err.Error())
+					}
+					c.begin()
+					s.sendResponse(c, req, nil, err)
+					req.cancel()
+					c.end()
+				}
+				break
+			}
+			// send a response if we actually managed to read a header.
+			if req != nil {
+				c.begin()
+				s.sendResponse(c, req, nil, err)
+				req.cancel()
+				c.end()
+			}
+			continue
+		}
+		if isStreamFrame(&req.h) {
+			s.handleStreamFrame(c, req)
+			continue
+		}
+		if c.begin() {
+			s.sendResponse(c, req, nil, errServerClosedStatus)
+			req.cancel()
+			c.end()
+			continue
+		}
+		if err := s.authenticate(c, req); err != nil {
+			s.sendResponse(c, req, nil, err)
+			req.cancel()
+			c.end()
+			continue
+		}
+		if !s.rateLimiter.allow(c, req) {
+			s.sendResponse(c, req, nil, ErrRateLimited)
+			req.cancel()
+			c.end()
+			continue
+		}
+		if req.mtype.kind != unaryMethod && req.h.Flags&header.FlagOneWay != 0 {
+			// nobody would read the stream, the request is dropped
+			req.cancel()
+			c.end()
+			continue
+		}
+		queued, err := s.admit(c)
+		if err != nil {
+			s.sendResponse(c, req, nil, err)
+			req.cancel()
+			c.end()
 			continue
 		}
-		go s.Server.ServeCodec(codec.NewServerCodec(conn, s.Serializer))
+		if req.mtype.kind != unaryMethod {
+			req.stream = newServerStream(s, c, req)
+		}
+		c.track(req, true)
+		wg.Add(1)
+		go s.call(c, wg, req, queued)
+	}
+	cancel()
+	// We've seen that there are no more requests.
+	// Wait for responses to be sent before closing codec.
+	wg.Wait()
+	cc.Close()
+}
+
+// begin marks a request as in flight, it reports whether
+// the connection is draining
+func (c *serverConn) begin() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.active++
+	return c.draining
+}
+
+// end marks a request as answered, the connection of a
+// draining server is closed once the last request is answered
+func (c *serverConn) end() {
+	c.mu.Lock()
+	c.active--
+	if c.active == 0 {
+		c.idleSince = time.Now()
+	}
+	idle := c.draining && c.graceOver && c.active == 0
+	c.mu.Unlock()
+	if idle {
+		c.cc.Close()
+	}
+}
+
+// track registers the call of req so later frames of the client can find it
+func (c *serverConn) track(req *request, add bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if add {
+		if c.calls == nil {
+			c.calls = make(map[uint64]*request)
+		}
+		c.calls[req.h.ID] = req
+	} else if c.calls[req.h.ID] == req {
+		delete(c.calls, req.h.ID)
+	}
+}
+
+// isStreamFrame reports whether h belongs to a call which has already started
+func isStreamFrame(h *header.RequestHeader) bool {
+	const flags = header.FlagCancel | header.FlagStream | header.FlagEndStream | header.FlagWindowUpdate |
+		header.FlagPing | header.FlagPong
+	return h.Method == "" && h.Flags&flags != 0
+}
+
+// handleStreamFrame answers pings and applies other frames to the call
+// they belong to, frames of calls which have ended are dropped
+func (s *Server) handleStreamFrame(c *serverConn, frame *request) {
+	frame.cancel()
+	if frame.h.Flags&header.FlagPing != 0 {
+		if err := s.writeControl(c, frame.h.ID, frame.h.GetCompressType(), header.FlagPong); err != nil {
+			log.Println("tinyrpc: writing pong:", err)
+		}
+		return
+	}
+	if frame.h.Flags&header.FlagPong != 0 {
+		// the client is alive, which the read loop has noted already
+		return
+	}
+	c.mu.Lock()
+	req := c.calls[frame.h.ID]
+	c.mu.Unlock()
+	if req == nil {
+		return
+	}
+	flags := frame.h.Flags
+	if flags&header.FlagCancel != 0 {
+		req.cancel()
+		return
+	}
+	if req.stream == nil {
+		return
+	}
+	if flags&header.FlagWindowUpdate != 0 {
+		req.stream.out.grant(int(frame.h.Window))
+	}
+	if flags&header.FlagStream != 0 && !req.stream.in.push(frame.payload) {
+		req.stream.fail(errWindowExceeded)
+		return
+	}
+	if flags&header.FlagEndStream != 0 {
+		req.stream.in.close(io.EOF, false)
+	}
+}
+
+// drain tells the client to stop sending requests and closes the
+// connection once it is idle and drainGrace has passed
+func (c *serverConn) drain() {
+	c.mu.Lock()
+	if c.draining {
+		c.mu.Unlock()
+		return
+	}
+	c.draining = true
+	c.mu.Unlock()
+
+	h := header.ResponsePool.Get().(*header.ResponseHeader)
+	defer func() {
+		h.ResetHeader()
+		header.ResponsePool.Put(h)
+	}()
+	h.Flags = header.FlagGoAway
+	c.sending.Lock()
+	err := c.cc.WriteResponse(h, nil)
+	c.sending.Unlock()
+	if err != nil {
+		c.cc.Close()
+		return
+	}
+	time.AfterFunc(drainGrace, func() {
+		c.mu.Lock()
+		c.graceOver = true
+		idle := c.active == 0
+		c.mu.Unlock()
+		if idle {
+			c.cc.Close()
+		}
+	})
+}
+
+// request is a request being served
+type request struct {
+	h       header.RequestHeader
+	md      responseMetadata // set by the handler and interceptors
+	ctx     context.Context
+	cancel  context.CancelFunc
+	svc     *service
+	mtype   *methodType
+	argv    reflect.Value
+	replyv  reflect.Value
+	stream  *serverStream // nil for unary calls
+	payload []byte        // the message carried by a stream frame
+}
+
+func (s *Server) readRequest(ctx context.Context, cc codec.ServerCodec) (req *request, keepReading bool, err error) {
+	req = &request{}
+	if err = cc.ReadRequestHeader(&req.h); err != nil {
+		return nil, false, err
+	}
+	// the timeout starts when the header arrives
+	if req.h.Timeout > 0 {
+		req.ctx, req.cancel = context.WithTimeout(ctx, req.h.Timeout)
+	} else {
+		req.ctx, req.cancel = context.WithCancel(ctx)
+	}
+	if len(req.h.Metadata) > 0 {
+		req.ctx = metadata.NewIncomingContext(req.ctx, metadata.MD(req.h.Metadata))
+	}
+	req.ctx = context.WithValue(req.ctx, responseMetadataKey{}, &req.md)
+
+	// We read the header successfully. If we see an error now,
+	// we can still recover and move on to the next request.
+	keepReading = true
+
+	if isStreamFrame(&req.h) {
+		if req.h.Flags&header.FlagStream != 0 {
+			req.payload, err = cc.ReadRequestPayload(&req.h)
+			return req, err == nil, err
+		}
+		return req, keepReading, cc.ReadRequestBody(&req.h, nil)
+	}
+
+	req.svc, req.mtype, err = s.lookup(req.h.Method)
+	if err != nil {
+		// discard body
+		if e := cc.ReadRequestBody(&req.h, nil); e != nil {
+			return req, false, e
+		}
+		return req, keepReading, err
+	}
+
+	if req.mtype.kind == bidiStreamMethod {
+		// the messages of the client follow
+		if err = cc.ReadRequestBody(&req.h, nil); err != nil {
+			return req, false, err
+		}
+		return req, keepReading, nil
+	}
+
+	argv, argIsValue := req.mtype.newArgv()
+	if err = cc.ReadRequestBody(&req.h, argv.Interface()); err != nil {
+		var tooLarge *codec.TooLargeError
+		if errors.As(err, &tooLarge) {
+			// the body is still in the stream
+			return req, false, err
+		}
+		return req, keepReading, err
+	}
+	if argIsValue {
+		argv = argv.Elem()
+	}
+	req.argv = argv
+	if req.mtype.kind == unaryMethod {
+		req.replyv = req.mtype.newReplyv()
+	}
+	return req, keepReading, nil
+}
+
+func (s *Server) lookup(serviceMethod string) (svc *service, mtype *methodType, err error) {
+	dot := strings.LastIndex(serviceMethod, ".")
+	if dot < 0 {
+		return nil, nil, errors.New("rpc: service/method request ill-formed: " + serviceMethod)
+	}
+	serviceName := serviceMethod[:dot]
+	methodName := serviceMethod[dot+1:]
+
+	svci, ok := s.serviceMap.Load(serviceName)
+	if !ok {
+		return nil, nil, status.Error(codes.Unimplemented, "rpc: can't find service "+serviceMethod)
+	}
+	svc = svci.(*service)
+	mtype = svc.method[methodName]
+	if mtype == nil {
+		return nil, nil, status.Error(codes.Unimplemented, "rpc: can't find method "+serviceMethod)
+	}
+	return svc, mtype, nil
+}
+
+func (s *Server) call(c *serverConn, wg *sync.WaitGroup, req *request, queued bool) {
+	defer wg.Done()
+	defer c.end()
+	defer req.cancel()
+	defer c.track(req, false)
+
+	if err := s.acquire(c, req, queued); err != nil {
+		s.sendResponse(c, req, nil, err)
+		return
+	}
+	defer c.inFlight.release()
+	defer s.handlers.release()
+
+	// the client has already given up
+	if err := req.ctx.Err(); err != nil {
+		s.sendResponse(c, req, nil, status.FromContextError(err).Err())
+		return
+	}
+	if req.stream != nil {
+		s.callStream(c, req)
+		return
+	}
+	handler := func(ctx context.Context, args interface{}) (interface{}, error) {
+		argv := reflect.ValueOf(args)
+		if !argv.IsValid() || argv.Type() != req.mtype.ArgType {
+			return nil, errors.New("rpc: unexpected args type for " + req.h.Method)
+		}
+		err := req.svc.call(ctx, req.mtype, argv, req.replyv)
+		return req.replyv.Interface(), err
+	}
+
+	var reply interface{}
+	var err error
+	if s.interceptor == nil {
+		reply, err = handler(req.ctx, req.argv.Interface())
+	} else {
+		info := &UnaryServerInfo{
+			ServiceMethod: req.h.Method,
+			ID:            req.h.ID,
+			Timeout:       req.h.Timeout,
+		}
+		reply, err = s.interceptor(req.ctx, req.argv.Interface(), info, handler)
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		err = status.FromContextError(err).Err()
+	}
+	s.sendResponse(c, req, reply, err)
+}
+
+// callStream runs a stream method, its messages are sent as
+// they come and its error ends the stream
+func (s *Server) callStream(c *serverConn, req *request) {
+	stream := req.stream
+	if req.mtype.kind == serverStreamMethod {
+		stream.in.close(io.EOF, false)
+	} else if s.streamWindow > header.InitialWindow {
+		s.writeWindowUpdate(c, req, s.streamWindow-header.InitialWindow)
+	}
+	err := stream.finish(req.svc.callStream(req.mtype, req.argv, stream))
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		err = status.FromContextError(err).Err()
+	}
+	s.sendResponse(c, req, nil, err)
+}
+
+// sendResponse writes the response of req, the status of err is
+// sent along if err has been created by package status
+func (s *Server) sendResponse(c *serverConn, req *request, reply interface{}, err error) {
+	if err = s.writeResponse(c, req, 0, reply, err); err != nil {
+		log.Println("tinyrpc: writing response:", err)
+	}
+}
+
+// writeWindowUpdate grants the client n more messages on the stream of req
+func (s *Server) writeWindowUpdate(c *serverConn, req *request, n int) error {
+	h := header.ResponsePool.Get().(*header.ResponseHeader)
+	defer func() {
+		h.ResetHeader()
+		header.ResponsePool.Put(h)
+	}()
+	h.ID = req.h.ID
+	h.CompressType = req.h.GetCompressType()
+	h.Flags = header.FlagWindowUpdate
+	h.Window = uint32(n)
+	c.sending.Lock()
+	defer c.sending.Unlock()
+	return c.cc.WriteResponse(h, nil)
+}
+
+// writeResponse writes a frame of the response of req, the response
+// metadata is only sent along with the last frame
+func (s *Server) writeResponse(c *serverConn, req *request, flags uint32, reply interface{}, err error) error {
+	if req.h.Flags&header.FlagOneWay != 0 {
+		// the client does not wait for a response
+		return nil
+	}
+	h := header.ResponsePool.Get().(*header.ResponseHeader)
+	defer func() {
+		h.ResetHeader()
+		header.ResponsePool.Put(h)
+	}()
+	h.ID = req.h.ID
+	h.CompressType = req.h.GetCompressType()
+	h.Flags = flags
+	if err != nil {
+		if st, ok := status.FromError(err); ok {
+			h.Code = uint32(st.Code())
+			h.Details = st.Details()
+		}
+		h.Error = status.Convert(err).Message()
+	}
+	if flags&header.FlagStream == 0 {
+		h.Metadata = req.md.get()
 	}
+	c.sending.Lock()
+	defer c.sending.Unlock()
+	return c.cc.WriteResponse(h, reply)
 }
@@ -0,0 +1,181 @@
+// Copyright 2022 <mzh.scnu@qq.com>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tinyrpc
+
+import (
+	"context"
+	"errors"
+	"go/token"
+	"reflect"
+)
+
+var (
+	typeOfError   = reflect.TypeOf((*error)(nil)).Elem()
+	typeOfContext = reflect.TypeOf((*context.Context)(nil)).Elem()
+)
+
+// methodKind tells how a method exchanges messages with the client
+type methodKind int
+
+const (
+	unaryMethod        methodKind = iota // args in, reply out
+	serverStreamMethod                   // args in, a stream of messages out
+	bidiStreamMethod                     // streams of messages both ways
+)
+
+type methodType struct {
+	method      reflect.Method
+	ArgType     reflect.Type // nil for bidiStreamMethod
+	ReplyType   reflect.Type // nil for stream methods
+	withContext bool         // the method takes a context.Context as its first argument
+	kind        methodKind
+}
+
+type service struct {
+	name   string                 // name of service
+	rcvr   reflect.Value          // receiver of methods for the service
+	typ    reflect.Type           // type of the receiver
+	method map[string]*methodType // registered methods
+}
+
+func newService(rcvr interface{}, name string, useName bool) (*service, error) {
+	s := &service{
+		typ:  reflect.TypeOf(rcvr),
+		rcvr: reflect.ValueOf(rcvr),
+	}
+	sname := reflect.Indirect(s.rcvr).Type().Name()
+	if useName {
+		sname = name
+	}
+	if sname == "" {
+		return nil, errors.New("rpc.Register: no service name for type " + s.typ.String())
+	}
+	if !useName && !token.IsExported(sname) {
+		return nil, errors.New("rpc.Register: type " + sname + " is not exported")
+	}
+	s.name = sname
+	s.method = suitableMethods(s.typ)
+	if len(s.method) == 0 {
+		return nil, errors.New("rpc.Register: type " + sname + " has no exported methods of suitable type")
+	}
+	return s, nil
+}
+
+// suitableMethods returns the methods of typ which look like
+//
+//	func (t *T) MethodName(args T1, reply *T2) error
+//	func (t *T) MethodName(ctx context.Context, args T1, reply *T2) error
+//	func (t *T) MethodName(args T1, stream tinyrpc.ServerStream) error
+//	func (t *T) MethodName(stream tinyrpc.ServerStream) error
+//
+// where T1 and T2 are exported or builtin types.
+func suitableMethods(typ reflect.Type) map[string]*methodType {
+	methods := make(map[string]*methodType)
+	for m := 0; m < typ.NumMethod(); m++ {
+		method := typ.Method(m)
+		mtype := method.Type
+		if !method.IsExported() {
+			continue
+		}
+		if mtype.NumIn() == 2 && mtype.In(1) == typeOfServerStream {
+			if mtype.NumOut() == 1 && mtype.Out(0) == typeOfError {
+				methods[method.Name] = &methodType{method: method, kind: bidiStreamMethod}
+			}
+			continue
+		}
+		withContext := mtype.NumIn() == 4 && mtype.In(1) == typeOfContext
+		in := 1
+		if withContext {
+			in++
+		}
+		if mtype.NumIn() != in+2 || mtype.NumOut() != 1 {
+			continue
+		}
+		argType := mtype.In(in)
+		if !isExportedOrBuiltinType(argType) {
+			continue
+		}
+		if !withContext && mtype.In(in+1) == typeOfServerStream {
+			if mtype.Out(0) == typeOfError {
+				methods[method.Name] = &methodType{
+					method:  method,
+					ArgType: argType,
+					kind:    serverStreamMethod,
+				}
+			}
+			continue
+		}
+		replyType := mtype.In(in + 1)
+		if replyType.Kind() != reflect.Ptr || !isExportedOrBuiltinType(replyType) {
+			continue
+		}
+		if mtype.Out(0) != typeOfError {
+			continue
+		}
+		methods[method.Name] = &methodType{
+			method:      method,
+			ArgType:     argType,
+			ReplyType:   replyType,
+			withContext: withContext,
+		}
+	}
+	return methods
+}
+
+func isExportedOrBuiltinType(t reflect.Type) bool {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	// PkgPath will be non-empty even for an exported type,
+	// so we need to check the type name as well.
+	return token.IsExported(t.Name()) || t.PkgPath() == ""
+}
+
+// newArgv allocates the argument the method is called with, argIsValue
+// reports whether the method takes it by value
+func (m *methodType) newArgv() (argv reflect.Value, argIsValue bool) {
+	if m.ArgType.Kind() == reflect.Ptr {
+		return reflect.New(m.ArgType.Elem()), false
+	}
+	return reflect.New(m.ArgType), true
+}
+
+func (m *methodType) newReplyv() reflect.Value {
+	replyv := reflect.New(m.ReplyType.Elem())
+	switch m.ReplyType.Elem().Kind() {
+	case reflect.Map:
+		replyv.Elem().Set(reflect.MakeMap(m.ReplyType.Elem()))
+	case reflect.Slice:
+		replyv.Elem().Set(reflect.MakeSlice(m.ReplyType.Elem(), 0, 0))
+	}
+	return replyv
+}
+
+// callStream invokes a stream method and returns its error,
+// argv is ignored by bidiStreamMethod
+func (s *service) callStream(m *methodType, argv reflect.Value, stream ServerStream) error {
+	in := []reflect.Value{s.rcvr, argv, reflect.ValueOf(&stream).Elem()}
+	if m.kind == bidiStreamMethod {
+		in = []reflect.Value{s.rcvr, in[2]}
+	}
+	returnValues := m.method.Func.Call(in)
+	if errInter := returnValues[0].Interface(); errInter != nil {
+		return errInter.(error)
+	}
+	return nil
+}
+
+// call invokes the method and returns its error
+func (s *service) call(ctx context.Context, m *methodType, argv, replyv reflect.Value) error {
+	in := []reflect.Value{s.rcvr, argv, replyv}
+	if m.withContext {
+		in = []reflect.Value{s.rcvr, reflect.ValueOf(&ctx).Elem(), argv, replyv}
+	}
+	returnValues := m.method.Func.Call(in)
+	if errInter := returnValues[0].Interface(); errInter != nil {
+		return errInter.(error)
+	}
+	return nil
+}
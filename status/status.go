@@ -0,0 +1,168 @@
+// Copyright 2022 <mzh.scnu@qq.com>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package status implements errors with a status code, a message and
+// optional structured details, they are carried in the response header.
+//
+// A service method returns one with Errorf or Error, the client gets it
+// back and inspects it with Code or FromError:
+//
+//	if status.Code(err) == codes.NotFound {
+//		...
+//	}
+package status
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/zehuamama/tinyrpc/codes"
+	"google.golang.org/protobuf/proto"
+)
+
+// Status is the status of a call, the zero value and nil are codes.OK
+type Status struct {
+	code    codes.Code
+	message string
+	details []byte
+}
+
+// New returns a Status of the code and the message
+func New(c codes.Code, msg string) *Status {
+	return &Status{code: c, message: msg}
+}
+
+// Newf returns New(c, fmt.Sprintf(format, a...))
+func Newf(c codes.Code, format string, a ...interface{}) *Status {
+	return New(c, fmt.Sprintf(format, a...))
+}
+
+// Error returns an error of the code and the message, nil if c is codes.OK
+func Error(c codes.Code, msg string) error {
+	return New(c, msg).Err()
+}
+
+// Errorf returns Error(c, fmt.Sprintf(format, a...))
+func Errorf(c codes.Code, format string, a ...interface{}) error {
+	return Error(c, fmt.Sprintf(format, a...))
+}
+
+// Code returns the status code
+func (s *Status) Code() codes.Code {
+	if s == nil {
+		return codes.OK
+	}
+	return s.code
+}
+
+// Message returns the message
+func (s *Status) Message() string {
+	if s == nil {
+		return ""
+	}
+	return s.message
+}
+
+// Details returns the structured details, nil if there are none
+func (s *Status) Details() []byte {
+	if s == nil {
+		return nil
+	}
+	return s.details
+}
+
+// WithDetails returns a copy of s with the details attached
+func (s *Status) WithDetails(details []byte) *Status {
+	return &Status{code: s.Code(), message: s.Message(), details: details}
+}
+
+// WithProtoDetails returns a copy of s with the serialized message as details
+func (s *Status) WithProtoDetails(m proto.Message) (*Status, error) {
+	details, err := proto.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	return s.WithDetails(details), nil
+}
+
+// ProtoDetails decodes the details into m
+func (s *Status) ProtoDetails(m proto.Message) error {
+	return proto.Unmarshal(s.Details(), m)
+}
+
+// Err returns an error which represents s, nil if s is codes.OK
+func (s *Status) Err() error {
+	if s.Code() == codes.OK {
+		return nil
+	}
+	return &statusError{s}
+}
+
+func (s *Status) String() string {
+	return fmt.Sprintf("rpc error: code = %s desc = %s", s.Code(), s.Message())
+}
+
+type statusError struct {
+	s *Status
+}
+
+func (e *statusError) Error() string {
+	return e.s.String()
+}
+
+// Status returns the status of the error
+func (e *statusError) Status() *Status {
+	return e.s
+}
+
+// Is reports whether target is an error of the same status
+func (e *statusError) Is(target error) bool {
+	t, ok := target.(*statusError)
+	if !ok {
+		return false
+	}
+	return e.s.code == t.s.code && e.s.message == t.s.message &&
+		string(e.s.details) == string(t.s.details)
+}
+
+// FromError returns the status of err. ok is false if err was not produced
+// by this package, the status is codes.Unknown with the message of err then.
+// A nil err is OK.
+func FromError(err error) (s *Status, ok bool) {
+	if err == nil {
+		return nil, true
+	}
+	var se interface{ Status() *Status }
+	if errors.As(err, &se) {
+		return se.Status(), true
+	}
+	return New(codes.Unknown, err.Error()), false
+}
+
+// Convert is FromError without the ok result
+func Convert(err error) *Status {
+	s, _ := FromError(err)
+	return s
+}
+
+// Code returns the status code of err, codes.Unknown if it has none
+func Code(err error) codes.Code {
+	return Convert(err).Code()
+}
+
+// FromContextError converts an error of a context to a status,
+// the status is codes.Unknown for other errors
+func FromContextError(err error) *Status {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, context.DeadlineExceeded):
+		return New(codes.DeadlineExceeded, err.Error())
+	case errors.Is(err, context.Canceled):
+		return New(codes.Canceled, err.Error())
+	default:
+		return New(codes.Unknown, err.Error())
+	}
+}
@@ -0,0 +1,71 @@
+// Copyright 2022 <mzh.scnu@qq.com>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package status
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zehuamama/tinyrpc/codes"
+	pb "github.com/zehuamama/tinyrpc/test.data/message"
+)
+
+// TestErrorf .
+func TestErrorf(t *testing.T) {
+	err := Errorf(codes.NotFound, "user %d not found", 7)
+	assert.Equal(t, "rpc error: code = NotFound desc = user 7 not found", err.Error())
+	assert.Equal(t, codes.NotFound, Code(err))
+
+	s, ok := FromError(fmt.Errorf("wrapped: %w", err))
+	assert.Equal(t, true, ok)
+	assert.Equal(t, codes.NotFound, s.Code())
+	assert.Equal(t, "user 7 not found", s.Message())
+
+	assert.Equal(t, nil, Error(codes.OK, "fine"))
+	assert.Equal(t, true, errors.Is(err, Error(codes.NotFound, "user 7 not found")))
+	assert.Equal(t, false, errors.Is(err, Error(codes.Internal, "user 7 not found")))
+}
+
+// TestCode .
+func TestCode(t *testing.T) {
+	cases := []struct {
+		name   string
+		err    error
+		expect codes.Code
+	}{
+		{"test-1", nil, codes.OK},
+		{"test-2", errors.New("boom"), codes.Unknown},
+		{"test-3", Error(codes.Unavailable, "later"), codes.Unavailable},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			assert.Equal(t, c.expect, Code(c.err))
+		})
+	}
+	assert.Equal(t, "Unauthenticated", codes.Unauthenticated.String())
+	assert.Equal(t, "Code(100)", codes.Code(100).String())
+}
+
+// TestStatus_ProtoDetails .
+func TestStatus_ProtoDetails(t *testing.T) {
+	s, err := New(codes.InvalidArgument, "bad request").WithProtoDetails(&pb.ArithRequest{A: 1, B: 2})
+	assert.Equal(t, nil, err)
+
+	details := &pb.ArithRequest{}
+	assert.Equal(t, nil, Convert(s.Err()).ProtoDetails(details))
+	assert.Equal(t, float64(1), details.A)
+	assert.Equal(t, float64(2), details.B)
+}
+
+// TestFromContextError .
+func TestFromContextError(t *testing.T) {
+	assert.Equal(t, codes.DeadlineExceeded, FromContextError(context.DeadlineExceeded).Code())
+	assert.Equal(t, codes.Canceled, FromContextError(context.Canceled).Code())
+	assert.Equal(t, codes.Unknown, FromContextError(errors.New("boom")).Code())
+	assert.Equal(t, (*Status)(nil), FromContextError(nil))
+}
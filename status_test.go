@@ -0,0 +1,65 @@
+package tinyrpc
+
+import (
+	"errors"
+	"net/rpc"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zehuamama/tinyrpc/codes"
+	"github.com/zehuamama/tinyrpc/status"
+	pb "github.com/zehuamama/tinyrpc/test.data/message"
+)
+
+type StatusService struct{}
+
+func (s *StatusService) Find(args *pb.ArithRequest, reply *pb.ArithResponse) error {
+	switch args.A {
+	case 0:
+		st, err := status.New(codes.InvalidArgument, "A is required").WithProtoDetails(args)
+		if err != nil {
+			return err
+		}
+		return st.Err()
+	case 1:
+		return status.Errorf(codes.NotFound, "%v not found", args.B)
+	case 2:
+		return errors.New("plain error")
+	}
+	reply.C = args.A
+	return nil
+}
+
+// TestStatus .
+func TestStatus(t *testing.T) {
+	server := NewServer()
+	err := server.Register(new(StatusService))
+	assert.Equal(t, nil, err)
+	client := serve(t, server)
+
+	resp := &pb.ArithResponse{}
+	err = client.Call("StatusService.Find", &pb.ArithRequest{A: 1, B: 42}, resp)
+	assert.Equal(t, codes.NotFound, status.Code(err))
+	assert.Equal(t, "42 not found", status.Convert(err).Message())
+	assert.Equal(t, false, IsTransportError(err))
+
+	err = client.Call("StatusService.Find", &pb.ArithRequest{A: 0, B: 3}, resp)
+	st, ok := status.FromError(err)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, codes.InvalidArgument, st.Code())
+	details := &pb.ArithRequest{}
+	assert.Equal(t, nil, st.ProtoDetails(details))
+	assert.Equal(t, float64(3), details.B)
+
+	// errors without a status keep their type
+	err = client.Call("StatusService.Find", &pb.ArithRequest{A: 2}, resp)
+	assert.Equal(t, rpc.ServerError("plain error"), err)
+	assert.Equal(t, codes.Unknown, status.Code(err))
+
+	err = client.Call("StatusService.Lost", &pb.ArithRequest{A: 2}, resp)
+	assert.Equal(t, codes.Unimplemented, status.Code(err))
+
+	err = client.Call("StatusService.Find", &pb.ArithRequest{A: 5}, resp)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, float64(5), resp.C)
+}
@@ -0,0 +1,309 @@
+// Copyright 2022 <mzh.scnu@qq.com>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tinyrpc
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/rpc"
+	"reflect"
+	"sync"
+
+	"github.com/zehuamama/tinyrpc/codes"
+	"github.com/zehuamama/tinyrpc/header"
+	"github.com/zehuamama/tinyrpc/status"
+)
+
+var typeOfServerStream = reflect.TypeOf((*ServerStream)(nil)).Elem()
+
+var (
+	// ErrStreamDone is returned by ServerStream.Send once the service method has returned
+	ErrStreamDone = errors.New("tinyrpc: stream is done")
+	// ErrSendClosed is returned by ClientStream.Send after CloseSend
+	ErrSendClosed = errors.New("tinyrpc: send on a closed stream")
+)
+
+// errWindowExceeded ends a stream whose peer sent more messages than it was granted
+var errWindowExceeded = status.Error(codes.ResourceExhausted, "tinyrpc: stream window exceeded")
+
+// ServerStream is the server side of a streaming call, it is passed to
+// service methods which look like
+//
+//	func (t *T) MethodName(args T1, stream tinyrpc.ServerStream) error
+//	func (t *T) MethodName(stream tinyrpc.ServerStream) error
+//
+// The first form is server-streaming, the method sends messages in reply to
+// args. The second one is client-streaming or bidirectional, the method
+// receives the messages of the client as well. The stream ends when the
+// method returns, the client gets the returned error.
+type ServerStream interface {
+	// Context returns the context of the call, it is done when the
+	// client abandons the stream
+	Context() context.Context
+	// Send sends a message to the client, it blocks while the client
+	// has not read enough of the messages sent before
+	Send(m interface{}) error
+	// Recv decodes the next message of the client into m, it returns
+	// io.EOF once the client has called CloseSend
+	Recv(m interface{}) error
+}
+
+type serverStream struct {
+	server *Server
+	conn   *serverConn
+	req    *request
+	in     *inbox
+	out    *window
+
+	sendMutex sync.Mutex // serializes Send
+
+	mutex sync.Mutex // protects following
+	done  bool       // the method has returned
+	err   error      // overrides the error of the method
+}
+
+func newServerStream(s *Server, c *serverConn, req *request) *serverStream {
+	return &serverStream{
+		server: s,
+		conn:   c,
+		req:    req,
+		in:     newInbox(s.streamWindow),
+		out:    newWindow(header.InitialWindow + int(req.h.Window)),
+	}
+}
+
+func (s *serverStream) Context() context.Context {
+	return s.req.ctx
+}
+
+func (s *serverStream) Send(m interface{}) error {
+	s.sendMutex.Lock()
+	defer s.sendMutex.Unlock()
+	if err := s.req.ctx.Err(); err != nil {
+		return err
+	}
+	if !s.out.acquire(s.req.ctx.Done()) {
+		return s.req.ctx.Err()
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.done {
+		return ErrStreamDone
+	}
+	return s.server.writeResponse(s.conn, s.req, header.FlagStream, m, nil)
+}
+
+func (s *serverStream) Recv(m interface{}) error {
+	payload, grant, err := s.in.pop(s.req.ctx)
+	if err != nil {
+		return err
+	}
+	if grant > 0 {
+		s.server.writeWindowUpdate(s.conn, s.req, grant)
+	}
+	return s.server.serializer.Unmarshal(payload, m)
+}
+
+// fail ends the stream with err, whatever the method returns
+func (s *serverStream) fail(err error) {
+	s.mutex.Lock()
+	if s.err == nil {
+		s.err = err
+	}
+	s.mutex.Unlock()
+	s.in.close(err, true)
+	s.req.cancel()
+}
+
+// finish marks the stream as done and returns the error which ends it
+func (s *serverStream) finish(err error) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.done = true
+	if s.err != nil {
+		return s.err
+	}
+	return err
+}
+
+// ClientStream is the client side of a streaming call. Messages are queued
+// as they arrive and decoded by Recv. Send and Recv may be called from
+// different goroutines, but neither of them concurrently.
+type ClientStream struct {
+	client *Client
+	call   *rpc.Call
+	seq    uint64
+	ctx    context.Context
+	cancel context.CancelFunc
+	in     *inbox
+	out    *window
+	done   chan struct{} // closed when the call has ended
+
+	sendMutex  sync.Mutex // protects sendClosed, serializes Send
+	sendClosed bool
+
+	mutex sync.Mutex // protects err
+	err   error      // overrides the error of the call
+}
+
+// NewStream starts a server-streaming call of serviceMethod, the messages sent
+// by the service method are read with Recv. The stream is abandoned when ctx is
+// done or Close is called. Client interceptors do not apply to streams.
+func (c *Client) NewStream(ctx context.Context, serviceMethod string, args interface{}) (*ClientStream, error) {
+	return c.newStream(ctx, serviceMethod, args)
+}
+
+// NewBidiStream starts a client-streaming or bidirectional call of serviceMethod,
+// messages are sent with Send until CloseSend and received with Recv. See NewStream.
+func (c *Client) NewBidiStream(ctx context.Context, serviceMethod string) (*ClientStream, error) {
+	return c.newStream(ctx, serviceMethod, nil)
+}
+
+func (c *Client) newStream(ctx context.Context, serviceMethod string, args interface{}) (*ClientStream, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	s := &ClientStream{
+		client: c,
+		call: &rpc.Call{
+			ServiceMethod: serviceMethod,
+			Args:          args,
+			Done:          make(chan *rpc.Call, 1),
+		},
+		ctx:    ctx,
+		cancel: cancel,
+		in:     newInbox(c.streamWindow),
+		out:    newWindow(header.InitialWindow),
+		done:   make(chan struct{}),
+	}
+	s.seq, _ = c.sendStream(ctx, s.call, s)
+	go s.watch()
+	return s, nil
+}
+
+// Context returns the context of the stream
+func (s *ClientStream) Context() context.Context {
+	return s.ctx
+}
+
+// Send sends a message to the service method, it blocks while the method has
+// not read enough of the messages sent before. It returns io.EOF if the stream
+// has ended, Recv returns its error then.
+func (s *ClientStream) Send(m interface{}) error {
+	s.sendMutex.Lock()
+	defer s.sendMutex.Unlock()
+	if s.sendClosed {
+		return ErrSendClosed
+	}
+	select {
+	case <-s.done:
+		return io.EOF
+	default:
+	}
+	if !s.out.acquire(s.done) {
+		return io.EOF
+	}
+	return s.client.writeStreamFrame(s.seq, header.FlagStream, 0, m)
+}
+
+// CloseSend tells the service method that no more messages are sent
+func (s *ClientStream) CloseSend() error {
+	s.sendMutex.Lock()
+	defer s.sendMutex.Unlock()
+	if s.sendClosed {
+		return nil
+	}
+	s.sendClosed = true
+	select {
+	case <-s.done:
+		return nil
+	default:
+	}
+	return s.client.writeStreamFrame(s.seq, header.FlagEndStream, 0, nil)
+}
+
+// Recv decodes the next message into m. It returns io.EOF once the service
+// method has returned successfully, otherwise the error of the call.
+func (s *ClientStream) Recv(m interface{}) error {
+	payload, grant, err := s.in.pop(context.Background())
+	if err != nil {
+		return err
+	}
+	if grant > 0 {
+		s.client.writeStreamFrame(s.seq, header.FlagWindowUpdate, grant, nil)
+	}
+	return s.client.serializer.Unmarshal(payload, m)
+}
+
+// CloseAndRecv closes the sending side of a client-streaming call, decodes
+// the reply into m and waits for the call to end
+func (s *ClientStream) CloseAndRecv(m interface{}) error {
+	if err := s.CloseSend(); err != nil {
+		return err
+	}
+	if err := s.Recv(m); err != nil {
+		if err == io.EOF {
+			return io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	for {
+		if _, _, err := s.in.pop(context.Background()); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+	}
+}
+
+// Close abandons the stream, the server is told to stop
+func (s *ClientStream) Close() error {
+	s.cancel()
+	return nil
+}
+
+// push queues a message, it is called by the input loop of the client
+func (s *ClientStream) push(payload []byte) {
+	if !s.in.push(payload) {
+		s.mutex.Lock()
+		s.err = errWindowExceeded
+		s.mutex.Unlock()
+		s.cancel()
+	}
+}
+
+// watch waits for the end of the call or for the stream to be abandoned
+func (s *ClientStream) watch() {
+	var err error
+	abandoned := false
+	select {
+	case call := <-s.call.Done:
+		err = call.Error
+	case <-s.ctx.Done():
+		if s.client.cancel(s.seq) {
+			s.client.sendCancel(s.seq)
+			err = s.ctx.Err()
+			abandoned = true
+		} else {
+			// the call has just ended
+			err = (<-s.call.Done).Error
+		}
+	}
+	s.cancel()
+	if err == nil {
+		err = io.EOF
+	}
+	s.mutex.Lock()
+	if s.err != nil {
+		err = s.err
+	}
+	s.mutex.Unlock()
+
+	s.in.close(err, abandoned)
+	close(s.done)
+}
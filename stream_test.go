@@ -0,0 +1,274 @@
+package tinyrpc
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zehuamama/tinyrpc/codes"
+	"github.com/zehuamama/tinyrpc/status"
+	pb "github.com/zehuamama/tinyrpc/test.data/message"
+)
+
+type CountService struct {
+	abandoned chan error
+}
+
+// Count sends A messages counting up from B
+func (s *CountService) Count(args *pb.ArithRequest, stream ServerStream) error {
+	for i := 0; i < int(args.A); i++ {
+		if err := stream.Send(&pb.ArithResponse{C: args.B + float64(i)}); err != nil {
+			return err
+		}
+	}
+	if args.A < 0 {
+		return status.Errorf(codes.InvalidArgument, "negative count")
+	}
+	return nil
+}
+
+// Forever sends messages until the client goes away
+func (s *CountService) Forever(args *pb.ArithRequest, stream ServerStream) error {
+	for {
+		if err := stream.Send(&pb.ArithResponse{C: args.A}); err != nil {
+			s.abandoned <- err
+			return err
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func recvAll(stream *ClientStream) ([]float64, error) {
+	var values []float64
+	for {
+		resp := &pb.ArithResponse{}
+		if err := stream.Recv(resp); err != nil {
+			return values, err
+		}
+		values = append(values, resp.C)
+	}
+}
+
+// TestServerStream .
+func TestServerStream(t *testing.T) {
+	server := NewServer()
+	err := server.Register(&CountService{})
+	assert.Equal(t, nil, err)
+	err = server.Register(new(pb.ArithService))
+	assert.Equal(t, nil, err)
+	client := serve(t, server)
+
+	stream, err := client.NewStream(context.Background(), "CountService.Count", &pb.ArithRequest{A: 3, B: 10})
+	assert.Equal(t, nil, err)
+	values, err := recvAll(stream)
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, []float64{10, 11, 12}, values)
+
+	stream, err = client.NewStream(context.Background(), "CountService.Count", &pb.ArithRequest{A: 0})
+	assert.Equal(t, nil, err)
+	values, err = recvAll(stream)
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, 0, len(values))
+
+	stream, err = client.NewStream(context.Background(), "CountService.Count", &pb.ArithRequest{A: -1})
+	assert.Equal(t, nil, err)
+	_, err = recvAll(stream)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	stream, err = client.NewStream(context.Background(), "CountService.Lost", &pb.ArithRequest{A: 1})
+	assert.Equal(t, nil, err)
+	_, err = recvAll(stream)
+	assert.Equal(t, codes.Unimplemented, status.Code(err))
+}
+
+// TestServerStream_Multiplexed .
+func TestServerStream_Multiplexed(t *testing.T) {
+	server := NewServer()
+	err := server.Register(&CountService{})
+	assert.Equal(t, nil, err)
+	err = server.Register(new(pb.ArithService))
+	assert.Equal(t, nil, err)
+	client := serve(t, server)
+
+	streams := make([]*ClientStream, 5)
+	for i := range streams {
+		streams[i], err = client.NewStream(context.Background(), "CountService.Count",
+			&pb.ArithRequest{A: 100, B: float64(i * 1000)})
+		assert.Equal(t, nil, err)
+	}
+	// unary calls share the connection
+	resp := &pb.ArithResponse{}
+	err = client.Call("ArithService.Add", &pb.ArithRequest{A: 20, B: 5}, resp)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, float64(25), resp.C)
+
+	for i, stream := range streams {
+		values, err := recvAll(stream)
+		assert.Equal(t, io.EOF, err)
+		assert.Equal(t, 100, len(values))
+		for j, v := range values {
+			assert.Equal(t, float64(i*1000+j), v)
+		}
+	}
+}
+
+// TestServerStream_Cancel .
+func TestServerStream_Cancel(t *testing.T) {
+	service := &CountService{abandoned: make(chan error, 1)}
+	server := NewServer()
+	err := server.Register(service)
+	assert.Equal(t, nil, err)
+	client := serve(t, server)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stream, err := client.NewStream(ctx, "CountService.Forever", &pb.ArithRequest{A: 7})
+	assert.Equal(t, nil, err)
+	resp := &pb.ArithResponse{}
+	assert.Equal(t, nil, stream.Recv(resp))
+	assert.Equal(t, float64(7), resp.C)
+
+	cancel()
+	_, err = recvAll(stream)
+	assert.Equal(t, context.Canceled, err)
+
+	// the handler sees the cancellation
+	select {
+	case err = <-service.abandoned:
+		assert.Equal(t, context.Canceled, err)
+	case <-time.After(time.Second):
+		t.Fatal("handler did not see the cancellation")
+	}
+
+	stream, err = client.NewStream(context.Background(), "CountService.Forever", &pb.ArithRequest{A: 7})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, stream.Recv(resp))
+	assert.Equal(t, nil, stream.Close())
+	_, err = recvAll(stream)
+	assert.Equal(t, context.Canceled, err)
+	<-service.abandoned
+}
+
+type BidiService struct {
+	release chan struct{}
+}
+
+// Sum adds up the messages of the client
+func (s *BidiService) Sum(stream ServerStream) error {
+	sum := 0.0
+	for {
+		args := &pb.ArithRequest{}
+		err := stream.Recv(args)
+		if err == io.EOF {
+			return stream.Send(&pb.ArithResponse{C: sum})
+		}
+		if err != nil {
+			return err
+		}
+		sum += args.A
+	}
+}
+
+// Echo doubles the messages of the client, the count follows the half-close
+func (s *BidiService) Echo(stream ServerStream) error {
+	count := 0
+	for {
+		args := &pb.ArithRequest{}
+		err := stream.Recv(args)
+		if err == io.EOF {
+			return stream.Send(&pb.ArithResponse{C: float64(count)})
+		}
+		if err != nil {
+			return err
+		}
+		count++
+		if err = stream.Send(&pb.ArithResponse{C: args.A * 2}); err != nil {
+			return err
+		}
+	}
+}
+
+// Lazy reads nothing until it is released
+func (s *BidiService) Lazy(stream ServerStream) error {
+	select {
+	case <-s.release:
+	case <-stream.Context().Done():
+		return stream.Context().Err()
+	}
+	return s.Sum(stream)
+}
+
+// TestClientStream .
+func TestClientStream(t *testing.T) {
+	server := NewServer()
+	err := server.Register(&BidiService{})
+	assert.Equal(t, nil, err)
+	client := serve(t, server)
+
+	stream, err := client.NewBidiStream(context.Background(), "BidiService.Sum")
+	assert.Equal(t, nil, err)
+	// more messages than the window
+	for i := 1; i <= 200; i++ {
+		assert.Equal(t, nil, stream.Send(&pb.ArithRequest{A: float64(i)}))
+	}
+	resp := &pb.ArithResponse{}
+	assert.Equal(t, nil, stream.CloseAndRecv(resp))
+	assert.Equal(t, float64(200*201/2), resp.C)
+	assert.Equal(t, ErrSendClosed, stream.Send(&pb.ArithRequest{}))
+}
+
+// TestBidiStream .
+func TestBidiStream(t *testing.T) {
+	server := NewServer()
+	err := server.Register(&BidiService{})
+	assert.Equal(t, nil, err)
+	client := serve(t, server)
+
+	stream, err := client.NewBidiStream(context.Background(), "BidiService.Echo")
+	assert.Equal(t, nil, err)
+	for i := 0; i < 100; i++ {
+		assert.Equal(t, nil, stream.Send(&pb.ArithRequest{A: float64(i)}))
+		resp := &pb.ArithResponse{}
+		assert.Equal(t, nil, stream.Recv(resp))
+		assert.Equal(t, float64(i*2), resp.C)
+	}
+
+	// the server still sends after the half-close
+	assert.Equal(t, nil, stream.CloseSend())
+	values, err := recvAll(stream)
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, []float64{100}, values)
+	assert.Equal(t, ErrSendClosed, stream.Send(&pb.ArithRequest{}))
+}
+
+// TestStream_FlowControl .
+func TestStream_FlowControl(t *testing.T) {
+	service := &BidiService{release: make(chan struct{})}
+	server := NewServer(WithStreamWindow(16))
+	err := server.Register(service)
+	assert.Equal(t, nil, err)
+	client := serve(t, server)
+
+	stream, err := client.NewBidiStream(context.Background(), "BidiService.Lazy")
+	assert.Equal(t, nil, err)
+	for i := 0; i < 16; i++ {
+		assert.Equal(t, nil, stream.Send(&pb.ArithRequest{A: 1}))
+	}
+	// the window is full until the server reads
+	sent := make(chan error, 1)
+	go func() {
+		sent <- stream.Send(&pb.ArithRequest{A: 1})
+	}()
+	select {
+	case <-sent:
+		t.Fatal("Send did not wait for the window")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(service.release)
+	assert.Equal(t, nil, <-sent)
+
+	resp := &pb.ArithResponse{}
+	assert.Equal(t, nil, stream.CloseAndRecv(resp))
+	assert.Equal(t, float64(17), resp.C)
+}
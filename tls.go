@@ -0,0 +1,63 @@
+// Copyright 2022 <mzh.scnu@qq.com>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tinyrpc
+
+import (
+	"crypto/tls"
+	"net"
+	"time"
+)
+
+// tlsHandshakeTimeout bounds the TLS handshake of a new connection
+const tlsHandshakeTimeout = 10 * time.Second
+
+// WithTLSConfig sets the TLS configuration of Server.ServeTLS and Dial,
+// connections opened by Dial use TLS then. For mutual TLS the server
+// sets ClientAuth and ClientCAs and the client sets Certificates.
+func WithTLSConfig(config *tls.Config) Option {
+	return func(o *options) {
+		o.tlsConfig = config
+	}
+}
+
+// DialTLS is like Dial but the connections use TLS with the config
+func DialTLS(network, addr string, config *tls.Config, opts ...Option) (*ClientConn, error) {
+	return Dial(network, addr, append(opts, WithTLSConfig(config))...)
+}
+
+// ServeTLS is like Serve but the connections use TLS. The certificate and
+// the key are loaded from certFile and keyFile unless they are empty and the
+// config of WithTLSConfig has them already. Handlers find the verified
+// client certificate in the peer of their context, see peer.FromContext.
+func (s *Server) ServeTLS(lis net.Listener, certFile, keyFile string) error {
+	config := &tls.Config{}
+	if s.tlsConfig != nil {
+		config = s.tlsConfig.Clone()
+	}
+	if certFile != "" || keyFile != "" || (len(config.Certificates) == 0 && config.GetCertificate == nil) {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return s.Serve(tls.NewListener(lis, config))
+}
+
+// handshake completes the TLS handshake of conn so its state is known
+// before the first request is read
+func handshake(conn *tls.Conn) (*tls.ConnectionState, error) {
+	if err := conn.SetDeadline(time.Now().Add(tlsHandshakeTimeout)); err != nil {
+		return nil, err
+	}
+	if err := conn.Handshake(); err != nil {
+		return nil, err
+	}
+	if err := conn.SetDeadline(time.Time{}); err != nil {
+		return nil, err
+	}
+	state := conn.ConnectionState()
+	return &state, nil
+}
@@ -0,0 +1,148 @@
+package tinyrpc
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zehuamama/tinyrpc/peer"
+	pb "github.com/zehuamama/tinyrpc/test.data/message"
+)
+
+type PeerService struct {
+	peers chan *peer.Peer
+}
+
+func (s *PeerService) Get(ctx context.Context, args *pb.ArithRequest, reply *pb.ArithResponse) error {
+	p, _ := peer.FromContext(ctx)
+	s.peers <- p
+	return nil
+}
+
+// testCert creates a certificate for name signed by parent, a self-signed
+// CA is created when parent is nil
+func testCert(t *testing.T, name string, parent *tls.Certificate) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	issuer, signer := template, interface{}(key)
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+	} else {
+		issuer, signer = parent.Leaf, parent.PrivateKey
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, issuer, &key.PublicKey, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+// writeCert writes the certificate and the key of cert as PEM files
+func writeCert(t *testing.T, cert tls.Certificate) (certFile, keyFile string) {
+	dir := t.TempDir()
+	certFile = filepath.Join(dir, "cert.pem")
+	keyFile = filepath.Join(dir, "key.pem")
+	key, err := x509.MarshalECPrivateKey(cert.PrivateKey.(*ecdsa.PrivateKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: key}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+// TestServer_ServeTLS .
+func TestServer_ServeTLS(t *testing.T) {
+	ca := testCert(t, "ca", nil)
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.Leaf)
+	certFile, keyFile := writeCert(t, testCert(t, "server", &ca))
+
+	server := NewServer(WithTLSConfig(&tls.Config{
+		ClientAuth: tls.RequireAndVerifyClientCert,
+		ClientCAs:  pool,
+	}))
+	peers := &PeerService{peers: make(chan *peer.Peer, 1)}
+	err := server.Register(peers)
+	assert.Equal(t, nil, err)
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Equal(t, nil, err)
+	go server.ServeTLS(lis, certFile, keyFile)
+	defer server.Close()
+
+	// mutual TLS, the handler sees the verified client certificate
+	cc, err := DialTLS("tcp", lis.Addr().String(), &tls.Config{
+		RootCAs:      pool,
+		Certificates: []tls.Certificate{testCert(t, "client", &ca)},
+	})
+	assert.Equal(t, nil, err)
+	defer cc.Close()
+	err = cc.Call("PeerService.Get", &pb.ArithRequest{}, &pb.ArithResponse{})
+	assert.Equal(t, nil, err)
+	p := <-peers.peers
+	assert.Equal(t, "client", p.Certificate().Subject.CommonName)
+	assert.NotEqual(t, nil, p.Addr)
+
+	// clients without a certificate are refused
+	conn, err := tls.Dial("tcp", lis.Addr().String(), &tls.Config{RootCAs: pool})
+	if err == nil {
+		client := NewClient(conn)
+		defer client.Close()
+		err = client.Call("PeerService.Get", &pb.ArithRequest{}, &pb.ArithResponse{})
+	}
+	assert.NotEqual(t, nil, err)
+
+	// clients which do not trust the server refuse it
+	_, err = DialTLS("tcp", lis.Addr().String(), &tls.Config{})
+	assert.NotEqual(t, nil, err)
+}
+
+// TestServer_Peer .
+func TestServer_Peer(t *testing.T) {
+	server := NewServer()
+	peers := &PeerService{peers: make(chan *peer.Peer, 1)}
+	err := server.Register(peers)
+	assert.Equal(t, nil, err)
+	client := serve(t, server)
+
+	err = client.Call("PeerService.Get", &pb.ArithRequest{}, &pb.ArithResponse{})
+	assert.Equal(t, nil, err)
+	p := <-peers.peers
+	assert.Equal(t, "127.0.0.1", p.Addr.(*net.TCPAddr).IP.String())
+	assert.Equal(t, true, p.TLS == nil)
+	assert.Equal(t, true, p.Certificate() == nil)
+}